@@ -16,6 +16,7 @@ import (
 
 	graphql_handler "github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/ratelimit"
 	"github.com/streadway/amqp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
@@ -55,7 +56,43 @@ func main() {
 	fmt.Println("Starting web server on port", configs.WebServerPort)
 	go webserver.Start()
 
-	grpcServer := grpc.NewServer()
+	var rateLimitStore ratelimit.Store
+	if configs.RateLimitRedisAddr != "" {
+		rateLimitStore, err = ratelimit.NewRedisStore(ratelimit.RedisConfig{Addresses: []string{configs.RateLimitRedisAddr}})
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		memoryStore, err := ratelimit.NewMemoryStore(ratelimit.MemoryStoreConfig{})
+		if err != nil {
+			panic(err)
+		}
+		defer memoryStore.Close()
+		rateLimitStore = memoryStore
+	}
+	// an unset RATE_LIMIT_BY_*_MAX_RPS leaves those calls unlimited
+	rateLimitOptions := []ratelimit.Option{}
+	if configs.RateLimitByIPMaxRPS > 0 {
+		rateLimitOptions = append(rateLimitOptions, ratelimit.WithIPLimit(ratelimit.Limit{
+			MaxRequestsPerSecond:  configs.RateLimitByIPMaxRPS,
+			BlockTimeMilliseconds: configs.RateLimitByIPBlockTimeMs,
+		}))
+	}
+	if configs.RateLimitByTokenMaxRPS > 0 {
+		rateLimitOptions = append(rateLimitOptions, ratelimit.WithTokenLimit(ratelimit.Limit{
+			MaxRequestsPerSecond:  configs.RateLimitByTokenMaxRPS,
+			BlockTimeMilliseconds: configs.RateLimitByTokenBlockTimeMs,
+		}))
+	}
+	rateLimiter, err := ratelimit.NewLimiter(rateLimitStore, rateLimitOptions...)
+	if err != nil {
+		panic(err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(ratelimit.UnaryServerInterceptor(rateLimiter)),
+		grpc.StreamInterceptor(ratelimit.StreamServerInterceptor(rateLimiter)),
+	)
 	orderService := service.NewOrderService(*createOrderUseCase, *listOrdersUseCase)
 	pb.RegisterOrderServiceServer(grpcServer, orderService)
 	reflection.Register(grpcServer)
@@ -12,6 +12,12 @@ type conf struct {
 	WebServerPort     string `mapstructure:"WEB_SERVER_PORT"`
 	GRPCServerPort    string `mapstructure:"GRPC_SERVER_PORT"`
 	GraphQLServerPort string `mapstructure:"GRAPHQL_SERVER_PORT"`
+
+	RateLimitRedisAddr          string `mapstructure:"RATE_LIMIT_REDIS_ADDRESS"`
+	RateLimitByIPMaxRPS         int64  `mapstructure:"RATE_LIMIT_BY_IP_MAX_RPS"`
+	RateLimitByIPBlockTimeMs    int64  `mapstructure:"RATE_LIMIT_BY_IP_BLOCK_TIME_MS"`
+	RateLimitByTokenMaxRPS      int64  `mapstructure:"RATE_LIMIT_BY_TOKEN_MAX_RPS"`
+	RateLimitByTokenBlockTimeMs int64  `mapstructure:"RATE_LIMIT_BY_TOKEN_BLOCK_TIME_MS"`
 }
 
 func LoadConfig(path string) (*conf, error) {
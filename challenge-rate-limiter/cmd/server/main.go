@@ -1,11 +1,21 @@
 package main
 
 import (
-	"challenge-rate-limiter/configs"
-	"challenge-rate-limiter/internal/infra/storage_adapters"
-	"challenge-rate-limiter/internal/infra/webserver"
-	"challenge-rate-limiter/internal/infra/webserver/middlewares"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/configs"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/logger"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/handlers"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -14,47 +24,245 @@ func main() {
 		panic(err)
 	}
 
-	webserver := webserver.NewWebServer(configs.WebServerPort)
-	storage_adapter, err := storage_adapters.InitRedisAdapter(configs.RedisAddr)
-	// storage_adapter, err := storage_adapters.InitMemoryAdapter()
+	logger, err := logger.NewLogger(os.Stdout, configs.LogFormat, configs.LogLevel)
 	if err != nil {
 		panic(err)
 	}
+	slog.SetDefault(logger)
 
-	customTokens := populateCustomTokens()
-	rateLimiterConfig := &middlewares.RateLimiterConfig{
-		LimitByIP: &middlewares.RateLimiterRateConfig{
-			MaxRequestsPerSecond:  configs.LimitByIPMaxRPS,
-			BlockTimeMilliseconds: configs.LimitByIPBlockTimeMs,
-		},
-		LimitByToken: &middlewares.RateLimiterRateConfig{
-			MaxRequestsPerSecond:  configs.LimitByTokenMaxRPS,
-			BlockTimeMilliseconds: configs.LimitByTokenBlockTimeMs,
-		},
-		StorageAdapter: storage_adapter,
-		CustomTokens:   &customTokens,
+	webserver := webserver.NewWebServer(configs.WebServerPort, logger)
+	memoryAdapterConfig := ratelimit.MemoryStoreConfig{
+		SweepInterval: time.Duration(configs.MemorySweepIntervalMs) * time.Millisecond,
+		MaxKeys:       configs.MemoryMaxKeys,
+		Shards:        configs.MemoryShards,
+	}
+	var store ratelimit.Store
+	var concurrencyStore ratelimit.ConcurrencyStore
+	// the aggregating store enforces every limit as a fixed window
+	aggregating := false
+	switch configs.StorageAdapter {
+	case "", "redis":
+		redisAdapter, err := ratelimit.NewRedisStore(ratelimit.RedisConfig{
+			Addresses:  splitAddresses(configs.RedisAddr),
+			MasterName: configs.RedisMasterName,
+			Username:   configs.RedisUsername,
+			Password:   configs.RedisPassword,
+			DB:         configs.RedisDB,
+			TLS:        configs.RedisTLS,
+			Logger:     logger,
+		})
+		if err != nil {
+			panic(err)
+		}
+		store, concurrencyStore = redisAdapter, redisAdapter
+		if configs.StorageSyncIntervalMs > 0 {
+			aggregatingAdapter := ratelimit.NewAggregatingStore(redisAdapter, time.Duration(configs.StorageSyncIntervalMs)*time.Millisecond)
+			defer aggregatingAdapter.Close()
+			store, aggregating = aggregatingAdapter, true
+		}
+	case "memory":
+		memoryAdapter, err := ratelimit.NewMemoryStore(memoryAdapterConfig)
+		if err != nil {
+			panic(err)
+		}
+		defer memoryAdapter.Close()
+		store, concurrencyStore = memoryAdapter, memoryAdapter
+	case "postgres":
+		postgresAdapter, err := ratelimit.NewPostgresStore(configs.PostgresDSN, ratelimit.SystemClock, logger)
+		if err != nil {
+			panic(err)
+		}
+		defer postgresAdapter.Close()
+		store = postgresAdapter
+	case "memcached":
+		memcachedAdapter, err := ratelimit.NewMemcachedStore(splitAddresses(configs.MemcachedAddr), ratelimit.SystemClock, logger)
+		if err != nil {
+			panic(err)
+		}
+		defer memcachedAdapter.Close()
+		store = memcachedAdapter
+	case "bolt":
+		boltAdapter, err := ratelimit.NewBoltStore(configs.BoltPath, ratelimit.SystemClock, logger)
+		if err != nil {
+			panic(err)
+		}
+		defer boltAdapter.Close()
+		store = boltAdapter
+	default:
+		panic(fmt.Errorf("unknown storage adapter %q", configs.StorageAdapter))
+	}
+
+	if concurrencyStore == nil && (configs.LimitByIPConcurrency > 0 || configs.LimitByTokenConcurrency > 0) {
+		logger.Warn("Concurrency limits need the redis or memory storage adapter", "storage_adapter", configs.StorageAdapter)
+	}
+
+	if configs.CircuitBreakerFailures > 0 {
+		circuitBreakerStore := ratelimit.NewCircuitBreakerStore(store, configs.CircuitBreakerFailures, time.Duration(configs.CircuitBreakerOpenMs)*time.Millisecond, ratelimit.SystemClock, logger)
+		store = circuitBreakerStore
+		if concurrencyStore != nil {
+			concurrencyStore = circuitBreakerStore.WrapConcurrency(concurrencyStore)
+		}
+	}
+
+	failureMode, err := ratelimit.ParseFailureMode(configs.StorageFailureMode)
+	if err != nil {
+		panic(err)
+	}
+	var fallbackStore ratelimit.Store
+	if failureMode == ratelimit.FailureModeLocal {
+		memoryAdapter, err := ratelimit.NewMemoryStore(memoryAdapterConfig)
+		if err != nil {
+			panic(err)
+		}
+		defer memoryAdapter.Close()
+		fallbackStore = memoryAdapter
+	}
+
+	ipAlgorithm, err := ratelimit.ParseAlgorithm(configs.LimitByIPAlgorithm)
+	if err != nil {
+		panic(err)
+	}
+	tokenAlgorithm, err := ratelimit.ParseAlgorithm(configs.LimitByTokenAlgorithm)
+	if err != nil {
+		panic(err)
+	}
+	if aggregating && (ipAlgorithm != ratelimit.AlgorithmFixedWindow || tokenAlgorithm != ratelimit.AlgorithmFixedWindow) {
+		panic(fmt.Errorf("STORAGE_SYNC_INTERVAL_MS only supports the %s algorithm", ratelimit.AlgorithmFixedWindow))
+	}
+	ipWindows, err := ratelimit.ParseWindows(configs.LimitByIPWindows)
+	if err != nil {
+		panic(err)
+	}
+	tokenWindows, err := ratelimit.ParseWindows(configs.LimitByTokenWindows)
+	if err != nil {
+		panic(err)
+	}
+
+	trustedProxies, err := ratelimit.ParseTrustedProxies(configs.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	ipKeyFunc, err := ratelimit.ParseKeyFunc(configs.LimitByIPKey, trustedProxies, []byte(configs.JWTSecret))
+	if err != nil {
+		panic(err)
+	}
+	tokenKeyFunc, err := ratelimit.ParseKeyFunc(configs.LimitByTokenKey, trustedProxies, []byte(configs.JWTSecret))
+	if err != nil {
+		panic(err)
+	}
+
+	allowList, err := ratelimit.ParseAccessList(configs.AllowIPs, configs.AllowTokens)
+	if err != nil {
+		panic(err)
+	}
+	denyList, err := ratelimit.ParseAccessList(configs.DenyIPs, configs.DenyTokens)
+	if err != nil {
+		panic(err)
+	}
+
+	metrics, err := ratelimit.NewMetrics(prometheus.DefaultRegisterer, store)
+	if err != nil {
+		panic(err)
 	}
 
-	rateLimiter := middlewares.NewRateLimiter(rateLimiterConfig)
-	webserver.Use(rateLimiter)
+	tokenRegistry := ratelimit.NewTokenRegistry(store, logger, []byte(configs.LogKeySecret))
+	stopTokenRegistry := tokenRegistry.Watch(time.Duration(configs.TokenRegistryRefreshMs) * time.Millisecond)
+	defer stopTokenRegistry()
+
+	rateLimiter, err := ratelimit.NewLimiter(store,
+		ratelimit.WithIPLimit(ratelimit.Limit{
+			MaxRequestsPerSecond:     configs.LimitByIPMaxRPS,
+			BlockTimeMilliseconds:    configs.LimitByIPBlockTimeMs,
+			Algorithm:                ipAlgorithm,
+			Burst:                    configs.LimitByIPBurst,
+			Windows:                  ipWindows,
+			MaxConcurrentRequests:    configs.LimitByIPConcurrency,
+			BlockMultiplier:          configs.LimitByIPBlockMultiplier,
+			MaxBlockTimeMilliseconds: configs.LimitByIPMaxBlockTimeMs,
+			BlockDecayMilliseconds:   configs.LimitByIPBlockDecayMs,
+		}),
+		ratelimit.WithTokenLimit(ratelimit.Limit{
+			MaxRequestsPerSecond:     configs.LimitByTokenMaxRPS,
+			BlockTimeMilliseconds:    configs.LimitByTokenBlockTimeMs,
+			Algorithm:                tokenAlgorithm,
+			Burst:                    configs.LimitByTokenBurst,
+			Windows:                  tokenWindows,
+			MaxConcurrentRequests:    configs.LimitByTokenConcurrency,
+			BlockMultiplier:          configs.LimitByTokenBlockMultiplier,
+			MaxBlockTimeMilliseconds: configs.LimitByTokenMaxBlockTimeMs,
+			BlockDecayMilliseconds:   configs.LimitByTokenBlockDecayMs,
+		}),
+		ratelimit.WithKeyFunc(ipKeyFunc),
+		ratelimit.WithTokenKeyFunc(tokenKeyFunc),
+		ratelimit.WithTokenRegistry(tokenRegistry),
+		ratelimit.WithAllowList(allowList),
+		ratelimit.WithDenyList(denyList),
+		ratelimit.WithTrustedProxies(trustedProxies),
+		ratelimit.WithFailureMode(failureMode, fallbackStore),
+		ratelimit.WithConcurrencyStore(concurrencyStore, time.Duration(configs.ConcurrencyLeaseTTLMs)*time.Millisecond),
+		ratelimit.WithMetrics(metrics),
+		ratelimit.WithXRateLimitHeaders(configs.XRateLimitHeaders),
+		ratelimit.WithLogger(logger),
+		ratelimit.WithLogKeySecret([]byte(configs.LogKeySecret)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	if configs.RateLimitPolicyFile != "" {
+		stopWatching, err := ratelimit.WatchPolicyFile(configs.RateLimitPolicyFile, rateLimiter)
+		if err != nil {
+			panic(err)
+		}
+		defer stopWatching()
+	}
+
+	webserver.UseRateLimiter(rateLimiter)
 
 	rootHandler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Hello World!"))
 	}
 	webserver.AddHandler("/", rootHandler, "GET")
+	webserver.AddUnlimitedHandler("/metrics", promhttp.Handler().ServeHTTP, "GET")
+
+	loginHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Logged in!"))
+	}
+	webserver.AddLimitedHandler("/login", loginHandler, "POST", &ratelimit.Policy{
+		Name: "login",
+		LimitByIP: &ratelimit.Limit{
+			BlockTimeMilliseconds: 60000,
+			Windows: []ratelimit.Window{
+				{MaxRequests: 5, WindowMilliseconds: 60000},
+			},
+		},
+	})
+
+	if configs.AdminAPIKey != "" {
+		adminAuth := middlewares.NewAdminAuth(configs.AdminAPIKey)
+		tokenPolicyHandler := handlers.NewTokenPolicyHandler(tokenRegistry)
+		webserver.AddHandler("/admin/tokens", tokenPolicyHandler.List, "GET", adminAuth)
+		webserver.AddHandler("/admin/tokens/{token}", tokenPolicyHandler.Get, "GET", adminAuth)
+		webserver.AddHandler("/admin/tokens/{token}", tokenPolicyHandler.Put, "PUT", adminAuth)
+		webserver.AddHandler("/admin/tokens/{token}", tokenPolicyHandler.Delete, "DELETE", adminAuth)
+
+		blockHandler := handlers.NewBlockHandler(store)
+		webserver.AddHandler("/admin/blocks", blockHandler.List, "GET", adminAuth)
+		webserver.AddHandler("/admin/blocks", blockHandler.Clear, "DELETE", adminAuth)
+		webserver.AddHandler("/admin/keys", blockHandler.GetKey, "GET", adminAuth)
+		webserver.AddHandler("/admin/keys", blockHandler.ResetKey, "DELETE", adminAuth)
+	}
+
 	webserver.Start()
 }
 
-func populateCustomTokens() map[string]*middlewares.RateLimiterRateConfig {
-	return map[string]*middlewares.RateLimiterRateConfig{
-		"ABC": {
-			MaxRequestsPerSecond:  20,
-			BlockTimeMilliseconds: 3000,
-		},
-		"DEF": {
-			MaxRequestsPerSecond:  20,
-			BlockTimeMilliseconds: 3000,
-		},
+func splitAddresses(value string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
 	}
+	return addresses
 }
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// newScenarioHandler builds the rate limiter with the defaults of the .env file
+// and the custom tokens of policies.yaml. The FakeClock replaces the sleeps
+// between the bursts of requests.
+func newScenarioHandler(t *testing.T, clock ratelimit.Clock) http.Handler {
+	store, err := ratelimit.NewMemoryStore(ratelimit.MemoryStoreConfig{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	rateLimiter, err := ratelimit.NewLimiter(store,
+		ratelimit.WithIPLimit(ratelimit.Limit{MaxRequestsPerSecond: 5, BlockTimeMilliseconds: 10000}),
+		ratelimit.WithTokenLimit(ratelimit.Limit{MaxRequestsPerSecond: 10, BlockTimeMilliseconds: 5000}),
+		ratelimit.WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policyFile, err := ratelimit.LoadPolicyFile("../../policies.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateLimiter.ApplyPolicyFile(policyFile)
+
+	return rateLimiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Hello World!"))
+	}))
+}
+
+func sendRequest(handler http.Handler, apiKey string) int {
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	if apiKey != "" {
+		request.Header.Set("API_KEY", apiKey)
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response.Code
+}
+
+func TestRateLimiterScenarios(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		apiKey      string
+		maxRequests int
+		blockTime   time.Duration
+	}{
+		{name: "block after 5 requests per second, no API-KEY", maxRequests: 5, blockTime: 10 * time.Second},
+		{name: "block after 10 requests per second, random API-KEY", apiKey: "XYZ", maxRequests: 10, blockTime: 5 * time.Second},
+		{name: "block after 20 requests per second, with API-KEY ABC", apiKey: "ABC", maxRequests: 20, blockTime: 3 * time.Second},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			clock := ratelimit.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			handler := newScenarioHandler(t, clock)
+
+			for i := 0; i < scenario.maxRequests; i++ {
+				assert.Equal(t, http.StatusOK, sendRequest(handler, scenario.apiKey), "request %d", i+1)
+				clock.Advance(time.Second / time.Duration(scenario.maxRequests+1))
+			}
+			assert.Equal(t, http.StatusTooManyRequests, sendRequest(handler, scenario.apiKey))
+
+			// still blocked after the one second window is over
+			clock.Advance(scenario.blockTime - time.Millisecond)
+			assert.Equal(t, http.StatusTooManyRequests, sendRequest(handler, scenario.apiKey))
+
+			clock.Advance(time.Millisecond)
+			assert.Equal(t, http.StatusOK, sendRequest(handler, scenario.apiKey))
+		})
+	}
+}
+
+func TestGivenABlockedIP_WhenAnAPIKeyIsSentFromTheSameAddress_ThenShouldBeLimitedByTheToken(t *testing.T) {
+	clock := ratelimit.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	handler := newScenarioHandler(t, clock)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, sendRequest(handler, ""))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, sendRequest(handler, ""))
+	assert.Equal(t, http.StatusOK, sendRequest(handler, "ABC"))
+}
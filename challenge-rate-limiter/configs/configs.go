@@ -5,10 +5,58 @@ import "github.com/spf13/viper"
 type conf struct {
 	LimitByIPMaxRPS         int64  `mapstructure:"LIMIT_BY_IP_MAX_RPS"`
 	LimitByIPBlockTimeMs    int64  `mapstructure:"LIMIT_BY_IP_BLOCK_TIME_MS"`
+	LimitByIPAlgorithm      string `mapstructure:"LIMIT_BY_IP_ALGORITHM"`
+	LimitByIPBurst          int64  `mapstructure:"LIMIT_BY_IP_BURST"`
+	LimitByIPWindows        string `mapstructure:"LIMIT_BY_IP_WINDOWS"`
 	LimitByTokenMaxRPS      int64  `mapstructure:"LIMIT_BY_TOKEN_MAX_RPS"`
 	LimitByTokenBlockTimeMs int64  `mapstructure:"LIMIT_BY_TOKEN_BLOCK_TIME_MS"`
+	LimitByTokenAlgorithm   string `mapstructure:"LIMIT_BY_TOKEN_ALGORITHM"`
+	LimitByTokenBurst       int64  `mapstructure:"LIMIT_BY_TOKEN_BURST"`
+	LimitByTokenWindows     string `mapstructure:"LIMIT_BY_TOKEN_WINDOWS"`
+	LimitByIPConcurrency    int64  `mapstructure:"LIMIT_BY_IP_MAX_CONCURRENT"`
+	LimitByTokenConcurrency int64  `mapstructure:"LIMIT_BY_TOKEN_MAX_CONCURRENT"`
+	ConcurrencyLeaseTTLMs   int64  `mapstructure:"CONCURRENCY_LEASE_TTL_MS"`
+	LimitByIPKey            string `mapstructure:"LIMIT_BY_IP_KEY"`
+	LimitByTokenKey         string `mapstructure:"LIMIT_BY_TOKEN_KEY"`
+	TrustedProxies          string `mapstructure:"TRUSTED_PROXIES"`
+	JWTSecret               string `mapstructure:"JWT_SECRET"`
+	XRateLimitHeaders       bool   `mapstructure:"X_RATELIMIT_HEADERS"`
+	RateLimitPolicyFile     string `mapstructure:"RATE_LIMIT_POLICY_FILE"`
+	TokenRegistryRefreshMs  int64  `mapstructure:"TOKEN_REGISTRY_REFRESH_MS"`
+	AdminAPIKey             string `mapstructure:"ADMIN_API_KEY"`
+	StorageFailureMode      string `mapstructure:"STORAGE_FAILURE_MODE"`
+	StorageAdapter          string `mapstructure:"STORAGE_ADAPTER"`
+	PostgresDSN             string `mapstructure:"POSTGRES_DSN"`
+	MemcachedAddr           string `mapstructure:"MEMCACHED_ADDRESS"`
+	BoltPath                string `mapstructure:"BOLT_PATH"`
+	StorageSyncIntervalMs   int64  `mapstructure:"STORAGE_SYNC_INTERVAL_MS"`
+	CircuitBreakerFailures  int64  `mapstructure:"CIRCUIT_BREAKER_FAILURES"`
+	CircuitBreakerOpenMs    int64  `mapstructure:"CIRCUIT_BREAKER_OPEN_MS"`
+	MemorySweepIntervalMs   int64  `mapstructure:"MEMORY_SWEEP_INTERVAL_MS"`
+	MemoryMaxKeys           int    `mapstructure:"MEMORY_MAX_KEYS"`
+	MemoryShards            int    `mapstructure:"MEMORY_SHARDS"`
 	WebServerPort           string `mapstructure:"WEB_SERVER_PORT"`
+	LogFormat               string `mapstructure:"LOG_FORMAT"`
+	LogLevel                string `mapstructure:"LOG_LEVEL"`
+	LogKeySecret            string `mapstructure:"LOG_KEY_SECRET"`
 	RedisAddr               string `mapstructure:"REDIS_ADDRESS"`
+	RedisMasterName         string `mapstructure:"REDIS_MASTER_NAME"`
+	RedisUsername           string `mapstructure:"REDIS_USERNAME"`
+	RedisPassword           string `mapstructure:"REDIS_PASSWORD"`
+	RedisDB                 int    `mapstructure:"REDIS_DB"`
+	RedisTLS                bool   `mapstructure:"REDIS_TLS"`
+
+	LimitByIPBlockMultiplier    float64 `mapstructure:"LIMIT_BY_IP_BLOCK_MULTIPLIER"`
+	LimitByIPMaxBlockTimeMs     int64   `mapstructure:"LIMIT_BY_IP_MAX_BLOCK_TIME_MS"`
+	LimitByIPBlockDecayMs       int64   `mapstructure:"LIMIT_BY_IP_BLOCK_DECAY_MS"`
+	LimitByTokenBlockMultiplier float64 `mapstructure:"LIMIT_BY_TOKEN_BLOCK_MULTIPLIER"`
+	LimitByTokenMaxBlockTimeMs  int64   `mapstructure:"LIMIT_BY_TOKEN_MAX_BLOCK_TIME_MS"`
+	LimitByTokenBlockDecayMs    int64   `mapstructure:"LIMIT_BY_TOKEN_BLOCK_DECAY_MS"`
+
+	AllowIPs    string `mapstructure:"ALLOW_IPS"`
+	AllowTokens string `mapstructure:"ALLOW_TOKENS"`
+	DenyIPs     string `mapstructure:"DENY_IPS"`
+	DenyTokens  string `mapstructure:"DENY_TOKENS"`
 }
 
 func LoadConfig(path string) (*conf, error) {
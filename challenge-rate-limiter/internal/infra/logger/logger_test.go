@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenTheJSONFormat_WhenLoggingBelowTheLevel_ThenShouldOnlyWriteTheEnabledRecords(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, err := NewLogger(output, "json", "warn")
+	assert.Nil(t, err)
+
+	logger.Info("ignored")
+	logger.Warn("written", "key_type", "IP")
+
+	record := map[string]any{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &record))
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, "written", record["msg"])
+	assert.Equal(t, "IP", record["key_type"])
+}
+
+func TestGivenAnInvalidFormatOrLevel_WhenBuildingTheLogger_ThenShouldFail(t *testing.T) {
+	_, err := NewLogger(&bytes.Buffer{}, "xml", "info")
+	assert.NotNil(t, err)
+
+	_, err = NewLogger(&bytes.Buffer{}, "text", "verbose")
+	assert.NotNil(t, err)
+}
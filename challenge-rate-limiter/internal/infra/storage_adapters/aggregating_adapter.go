@@ -0,0 +1,291 @@
+package storage_adapters
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultSyncInterval = 100 * time.Millisecond
+
+type aggregateKey struct {
+	keyType string
+	key     string
+	window  time.Duration
+}
+
+type blockKey struct {
+	keyType string
+	key     string
+}
+
+// aggregateCounter is the replica view of a fixed window: the global count
+// read on the last sync plus the accesses admitted locally since then.
+type aggregateCounter struct {
+	start   time.Time
+	window  time.Duration
+	global  int64
+	pending int64
+}
+
+// AggregatingAdapter approximates a global limit without a Redis round trip
+// per request. Each replica admits accesses against its last known global
+// count and syncs the deltas to Redis every syncInterval. Every limit is
+// enforced as a fixed window, sharing its state with the fixed window
+// algorithm of the RedisAdapter.
+//
+// Replicas don't see each other's accesses until the next sync, so the limit
+// can be overshot by up to the accesses admitted by the other replicas during
+// one syncInterval. The time is read from the clock of the RedisAdapter.
+//
+// The first limit of every other algorithm logs a warning, as it is enforced
+// as a fixed window too.
+type AggregatingAdapter struct {
+	*RedisAdapter
+	syncInterval time.Duration
+
+	mutex            sync.Mutex
+	counters         map[aggregateKey]*aggregateCounter
+	blocks           map[blockKey]time.Time
+	warnedAlgorithms map[Algorithm]bool
+
+	stopSync  chan struct{}
+	syncDone  chan struct{}
+	closeOnce sync.Once
+}
+
+func NewAggregatingAdapter(redisAdapter *RedisAdapter, syncInterval time.Duration) *AggregatingAdapter {
+	if syncInterval <= 0 {
+		syncInterval = defaultSyncInterval
+	}
+
+	adapter := newAggregatingAdapter(redisAdapter, syncInterval)
+	go adapter.syncLoop()
+
+	return adapter
+}
+
+func newAggregatingAdapter(redisAdapter *RedisAdapter, syncInterval time.Duration) *AggregatingAdapter {
+	return &AggregatingAdapter{
+		RedisAdapter:     redisAdapter,
+		syncInterval:     syncInterval,
+		counters:         map[aggregateKey]*aggregateCounter{},
+		blocks:           map[blockKey]time.Time{},
+		warnedAlgorithms: map[Algorithm]bool{},
+		stopSync:         make(chan struct{}),
+		syncDone:         make(chan struct{}),
+	}
+}
+
+// Close stops the sync goroutine after pushing the pending deltas.
+func (a *AggregatingAdapter) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.stopSync)
+		<-a.syncDone
+	})
+	return nil
+}
+
+func (a *AggregatingAdapter) syncLoop() {
+	defer close(a.syncDone)
+
+	ticker := time.NewTicker(a.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopSync:
+			a.sync(context.Background())
+			return
+		case <-ticker.C:
+			a.sync(context.Background())
+		}
+	}
+}
+
+func (a *AggregatingAdapter) AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error) {
+	success, count, _, err := a.CheckAccess(ctx, keyType, key, limit, -1)
+	return success, count, err
+}
+
+func (a *AggregatingAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error) {
+	now := a.clock.Now()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	counter := a.counter(keyType, key, limit, now)
+	counter.pending += limit.cost()
+	return counter.global + counter.pending, nil
+}
+
+func (a *AggregatingAdapter) RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error {
+	now := a.clock.Now()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// the access was admitted in a window that already ended
+	counter, ok := a.counters[aggregateKey{keyType: keyType, key: key, window: limit.window()}]
+	if ok && counter.start.Equal(now.Truncate(limit.window())) {
+		counter.pending -= limit.cost()
+	}
+	return nil
+}
+
+func (a *AggregatingAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	now := a.clock.Now()
+
+	a.mutex.Lock()
+	if blockMilliseconds >= 0 {
+		if blockedUntil, ok := a.blocks[blockKey{keyType, key}]; ok && blockedUntil.After(now) {
+			a.mutex.Unlock()
+			return false, 0, &blockedUntil, nil
+		}
+	}
+
+	counter := a.counter(keyType, key, limit, now)
+	count := counter.global + counter.pending
+	if count+limit.cost() <= limit.MaxAccesses {
+		counter.pending += limit.cost()
+		a.mutex.Unlock()
+		return true, count + limit.cost(), nil, nil
+	}
+
+	if blockMilliseconds <= 0 {
+		a.mutex.Unlock()
+		return false, count, nil, nil
+	}
+
+	blockedUntil := now.Add(time.Duration(blockMilliseconds) * time.Millisecond)
+	a.blocks[blockKey{keyType, key}] = blockedUntil
+	a.mutex.Unlock()
+
+	// blocks are rare, so they are shared with the other replicas right away
+	if _, err := a.RedisAdapter.AddBlock(ctx, keyType, key, blockMilliseconds); err != nil {
+		a.logger.Error("Error sharing block", "error", err)
+	}
+	return false, count, &blockedUntil, nil
+}
+
+// counter is the counter of the current window, a.mutex must be held.
+func (a *AggregatingAdapter) counter(keyType string, key string, limit AccessLimit, now time.Time) *aggregateCounter {
+	if algorithm := limit.algorithm(); algorithm != AlgorithmFixedWindow && !a.warnedAlgorithms[algorithm] {
+		a.warnedAlgorithms[algorithm] = true
+		a.logger.Warn("Aggregating adapter enforcing the limit as a fixed window", "algorithm", algorithm)
+	}
+
+	counterKey := aggregateKey{keyType: keyType, key: key, window: limit.window()}
+	counter, ok := a.counters[counterKey]
+	start := now.Truncate(limit.window())
+	if !ok || !counter.start.Equal(start) {
+		counter = &aggregateCounter{start: start, window: limit.window()}
+		a.counters[counterKey] = counter
+	}
+	return counter
+}
+
+func (a *AggregatingAdapter) AddBlock(ctx context.Context, keyType string, key string, milliseconds int64) (*time.Time, error) {
+	blockedUntil, err := a.RedisAdapter.AddBlock(ctx, keyType, key, milliseconds)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	a.blocks[blockKey{keyType, key}] = *blockedUntil
+	a.mutex.Unlock()
+	return blockedUntil, nil
+}
+
+func (a *AggregatingAdapter) ClearBlock(ctx context.Context, keyType string, key string) (bool, error) {
+	a.mutex.Lock()
+	delete(a.blocks, blockKey{keyType, key})
+	a.mutex.Unlock()
+
+	return a.RedisAdapter.ClearBlock(ctx, keyType, key)
+}
+
+func (a *AggregatingAdapter) ResetKey(ctx context.Context, keyType string, key string) error {
+	a.mutex.Lock()
+	delete(a.blocks, blockKey{keyType, key})
+	for counterKey := range a.counters {
+		if counterKey.keyType == keyType && counterKey.key == key {
+			delete(a.counters, counterKey)
+		}
+	}
+	a.mutex.Unlock()
+
+	return a.RedisAdapter.ResetKey(ctx, keyType, key)
+}
+
+type aggregateDelta struct {
+	counterKey aggregateKey
+	counter    *aggregateCounter
+	delta      int64
+	cmd        *redis.Cmd
+}
+
+// sync pushes the pending deltas of every counter of the current windows in a
+// single pipeline and refreshes their global counts and blocks.
+func (a *AggregatingAdapter) sync(ctx context.Context) {
+	now := a.clock.Now()
+	deltas := []*aggregateDelta{}
+
+	a.mutex.Lock()
+	for blockKey, blockedUntil := range a.blocks {
+		if !blockedUntil.After(now) {
+			delete(a.blocks, blockKey)
+		}
+	}
+	for counterKey, counter := range a.counters {
+		if !counter.start.Add(counter.window).After(now) {
+			delete(a.counters, counterKey)
+			continue
+		}
+		deltas = append(deltas, &aggregateDelta{counterKey: counterKey, counter: counter, delta: counter.pending})
+		counter.global += counter.pending
+		counter.pending = 0
+	}
+	a.mutex.Unlock()
+
+	if len(deltas) == 0 {
+		return
+	}
+
+	pipe := a.client.Pipeline()
+	for _, delta := range deltas {
+		limit := AccessLimit{Window: delta.counterKey.window}
+		keys := []string{
+			a.customRedisKey("block", delta.counterKey.keyType, delta.counterKey.key),
+			a.limitRedisKey("window", delta.counterKey.keyType, delta.counterKey.key, limit),
+		}
+		delta.cmd = syncScript.Eval(ctx, pipe, keys, delta.counter.start.UnixMicro(), delta.delta, limit.window().Milliseconds())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.logger.Error("Error syncing accesses", "error", err)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, delta := range deltas {
+		result, err := delta.cmd.Slice()
+		if err != nil {
+			// keep the delta for the next sync
+			delta.counter.global -= delta.delta
+			delta.counter.pending += delta.delta
+			continue
+		}
+
+		if global := result[0].(int64); global > 0 {
+			delta.counter.global = global
+		}
+		if len(result) > 1 && result[1] != nil {
+			if blockedUntil, err := parseBlockTime(result[1].(string)); err == nil {
+				a.blocks[blockKey{delta.counterKey.keyType, delta.counterKey.key}] = *blockedUntil
+			}
+		}
+	}
+}
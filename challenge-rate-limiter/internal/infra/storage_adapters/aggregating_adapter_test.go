@@ -0,0 +1,160 @@
+package storage_adapters
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplicas(t *testing.T, count int) []*AggregatingAdapter {
+	server := miniredis.RunT(t)
+	replicas := []*AggregatingAdapter{}
+	for i := 0; i < count; i++ {
+		redisAdapter := NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr()}), nil, nil)
+		// syncs are triggered by the tests, one per simulated interval
+		replicas = append(replicas, newAggregatingAdapter(redisAdapter, time.Hour))
+	}
+	return replicas
+}
+
+// measureOvershoot sends requestsPerSync requests to every replica between
+// syncs until the limit is reached, returning how many accesses were admitted
+// over the limit.
+func measureOvershoot(t *testing.T, replicas []*AggregatingAdapter, limit AccessLimit, requestsPerSync int) int64 {
+	ctx := context.Background()
+	admitted := int64(0)
+	for round := 0; ; round++ {
+		admittedInRound := int64(0)
+		for _, replica := range replicas {
+			for i := 0; i < requestsPerSync; i++ {
+				success, _, _, err := replica.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+				assert.Nil(t, err)
+				if success {
+					admittedInRound++
+				}
+			}
+		}
+		for _, replica := range replicas {
+			replica.sync(ctx)
+		}
+
+		admitted += admittedInRound
+		if admittedInRound == 0 {
+			break
+		}
+	}
+	return admitted - limit.MaxAccesses
+}
+
+func TestGivenSeveralReplicas_WhenTheLimitIsReached_ThenShouldOvershootByAtMostOneSyncIntervalOfTheOtherReplicas(t *testing.T) {
+	tests := []struct {
+		replicas        int
+		requestsPerSync int
+	}{
+		{1, 10},
+		{3, 10},
+		{3, 1},
+		{5, 25},
+	}
+	for _, test := range tests {
+		replicas := newTestReplicas(t, test.replicas)
+		limit := AccessLimit{MaxAccesses: 100, Window: time.Hour}
+
+		overshoot := measureOvershoot(t, replicas, limit, test.requestsPerSync)
+		bound := int64((test.replicas - 1) * test.requestsPerSync)
+		t.Logf("%d replicas, %d requests per sync: overshoot of %d over %d (bound %d)", test.replicas, test.requestsPerSync, overshoot, limit.MaxAccesses, bound)
+
+		assert.GreaterOrEqual(t, overshoot, int64(0))
+		assert.LessOrEqual(t, overshoot, bound)
+	}
+}
+
+func TestGivenAReplicaBlockingAKey_WhenTheOtherReplicaSyncs_ThenShouldDenyTheKey(t *testing.T) {
+	ctx := context.Background()
+	replicas := newTestReplicas(t, 2)
+	limit := AccessLimit{MaxAccesses: 1, Window: time.Hour}
+
+	success, _, _, err := replicas[0].CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+
+	success, _, blockedUntil, err := replicas[0].CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.NotNil(t, blockedUntil)
+
+	replicas[1].CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	replicas[1].sync(ctx)
+
+	success, _, blockedUntil, err = replicas[1].CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.NotNil(t, blockedUntil)
+}
+
+func TestGivenAChargeOnAReplica_WhenTheOtherReplicaSyncs_ThenShouldCountTheCharge(t *testing.T) {
+	ctx := context.Background()
+	replicas := newTestReplicas(t, 2)
+	limit := AccessLimit{MaxAccesses: 10, Window: time.Hour, Cost: 8}
+
+	replicas[1].AddAccess(ctx, "IP", "10.0.0.1", AccessLimit{MaxAccesses: 10, Window: time.Hour})
+	count, err := replicas[0].ChargeAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), count)
+
+	replicas[0].sync(ctx)
+	replicas[1].sync(ctx)
+
+	limit.Cost = 2
+	success, count, _, err := replicas[1].CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Equal(t, int64(9), count)
+}
+
+func TestGivenARefundedAccess_WhenSyncing_ThenShouldRemoveItFromTheGlobalCount(t *testing.T) {
+	replicas := newTestReplicas(t, 2)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 3, Window: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		success, _, _, err := replicas[0].CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+		assert.Nil(t, err)
+		assert.True(t, success)
+	}
+	assert.Nil(t, replicas[0].RefundAccess(ctx, "IP", "10.0.0.1", limit))
+	replicas[0].sync(ctx)
+
+	success, count, _, err := replicas[1].CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	replicas[1].sync(ctx)
+	assert.Equal(t, int64(1), count)
+
+	usage, err := replicas[1].GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), usage[0].Count)
+}
+
+func TestGivenALimitOfAnotherAlgorithm_WhenTheAggregatingAdapterChecksIt_ThenShouldWarnOnceThatItIsAFixedWindow(t *testing.T) {
+	server := miniredis.RunT(t)
+	output := &bytes.Buffer{}
+	redisAdapter := NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr()}), nil, slog.New(slog.NewTextHandler(output, nil)))
+	adapter := newAggregatingAdapter(redisAdapter, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		adapter.AddAccess(ctx, "IP", "10.0.0.1", AccessLimit{MaxAccesses: 10, Window: time.Minute, Algorithm: AlgorithmFixedWindow})
+		adapter.AddAccess(ctx, "IP", "10.0.0.1", AccessLimit{MaxAccesses: 10, Window: time.Second, Algorithm: AlgorithmTokenBucket})
+	}
+
+	assert.Equal(t, 1, strings.Count(output.String(), "enforcing the limit as a fixed window"))
+	assert.Contains(t, output.String(), "algorithm=token_bucket")
+}
@@ -0,0 +1,293 @@
+package storage_adapters
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const defaultAccessWindow = time.Second
+
+func ParseAlgorithm(value string) (Algorithm, error) {
+	switch Algorithm(value) {
+	case "", AlgorithmSlidingLog:
+		return AlgorithmSlidingLog, nil
+	case AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmGCRA:
+		return Algorithm(value), nil
+	}
+	return "", fmt.Errorf("unknown rate limit algorithm %q", value)
+}
+
+func (l AccessLimit) algorithm() Algorithm {
+	if l.Algorithm == "" {
+		return AlgorithmSlidingLog
+	}
+	return l.Algorithm
+}
+
+func (l AccessLimit) window() time.Duration {
+	if l.Window > 0 {
+		return l.Window
+	}
+	return defaultAccessWindow
+}
+
+func (l AccessLimit) cost() int64 {
+	if l.Cost > 0 {
+		return l.Cost
+	}
+	return 1
+}
+
+func (l AccessLimit) burst() int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.MaxAccesses
+}
+
+// emissionInterval is the time needed to earn back a single access.
+func (l AccessLimit) emissionInterval() time.Duration {
+	if l.MaxAccesses <= 0 {
+		return l.window()
+	}
+	return l.window() / time.Duration(l.MaxAccesses)
+}
+
+// Quota is the number of accesses available to an idle key.
+func (l AccessLimit) Quota() int64 {
+	switch l.algorithm() {
+	case AlgorithmTokenBucket, AlgorithmGCRA:
+		return l.burst()
+	}
+	return l.MaxAccesses
+}
+
+// ResetAfter estimates how long it takes for the whole quota to be available
+// again after count accesses were consumed.
+func (l AccessLimit) ResetAfter(count int64, now time.Time) time.Duration {
+	switch l.algorithm() {
+	case AlgorithmFixedWindow:
+		return now.Truncate(l.window()).Add(l.window()).Sub(now)
+	case AlgorithmTokenBucket, AlgorithmGCRA:
+		return time.Duration(count) * l.emissionInterval()
+	}
+	return l.window()
+}
+
+// RetryAfter estimates how long a denied key waits until the cost of one more
+// access fits.
+func (l AccessLimit) RetryAfter(now time.Time) time.Duration {
+	switch l.algorithm() {
+	case AlgorithmFixedWindow:
+		return now.Truncate(l.window()).Add(l.window()).Sub(now)
+	case AlgorithmTokenBucket, AlgorithmGCRA:
+		return time.Duration(l.cost()) * l.emissionInterval()
+	}
+	return l.window()
+}
+
+type accessState interface {
+	// take admits limit.Cost accesses when they fit in the limit, or always
+	// when forced.
+	take(limit AccessLimit, now time.Time, force bool) (bool, int64)
+	// refund gives back limit.Cost accesses taken by a previous take.
+	refund(limit AccessLimit, now time.Time)
+	usage(window time.Duration, now time.Time) Usage
+	// expiresAt is when the state becomes equivalent to a new one and can be
+	// discarded.
+	expiresAt(limit AccessLimit, now time.Time) time.Time
+}
+
+func newAccessState(algorithm Algorithm) accessState {
+	switch algorithm {
+	case AlgorithmFixedWindow:
+		return &fixedWindowState{}
+	case AlgorithmTokenBucket:
+		return &tokenBucketState{}
+	case AlgorithmGCRA:
+		return &gcraState{}
+	}
+	return &slidingLogState{}
+}
+
+// slidingLogState keeps the access times, in unix nanoseconds, on a ring
+// buffer that grows up to the limit of accesses.
+type slidingLogState struct {
+	accesses []int64
+	head     int
+	count    int
+}
+
+func (s *slidingLogState) take(limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	count := s.filterInWindow(limit.window(), now)
+	if !force && count+limit.cost() > limit.MaxAccesses {
+		return false, count
+	}
+
+	// a forced charge keeps only the latest MaxAccesses entries, which are
+	// enough to hold the key at its limit for a whole window
+	for i := int64(0); i < min(limit.cost(), limit.MaxAccesses); i++ {
+		for int64(s.count) >= limit.MaxAccesses {
+			s.head = (s.head + 1) % len(s.accesses)
+			s.count--
+		}
+		if s.count == len(s.accesses) {
+			s.grow(limit.MaxAccesses)
+		}
+		s.accesses[(s.head+s.count)%len(s.accesses)] = now.UnixNano()
+		s.count++
+	}
+	return true, count + limit.cost()
+}
+
+func (s *slidingLogState) refund(limit AccessLimit, now time.Time) {
+	// the latest accesses are dropped, they all count the same
+	s.count -= int(min(limit.cost(), int64(s.count)))
+}
+
+func (s *slidingLogState) grow(maxAccesses int64) {
+	size := int64(max(2*len(s.accesses), 4))
+	size = max(min(size, maxAccesses), int64(s.count+1))
+
+	accesses := make([]int64, size)
+	for i := 0; i < s.count; i++ {
+		accesses[i] = s.accesses[(s.head+i)%len(s.accesses)]
+	}
+	s.accesses, s.head = accesses, 0
+}
+
+func (s *slidingLogState) filterInWindow(window time.Duration, now time.Time) int64 {
+	oldest := now.UnixNano() - int64(window)
+	for s.count > 0 && s.accesses[s.head] <= oldest {
+		s.head = (s.head + 1) % len(s.accesses)
+		s.count--
+	}
+	return int64(s.count)
+}
+
+func (s *slidingLogState) usage(window time.Duration, now time.Time) Usage {
+	return Usage{Count: s.filterInWindow(window, now)}
+}
+
+func (s *slidingLogState) expiresAt(limit AccessLimit, now time.Time) time.Time {
+	return now.Add(limit.window())
+}
+
+type fixedWindowState struct {
+	start time.Time
+	count int64
+}
+
+func (s *fixedWindowState) take(limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	start := now.Truncate(limit.window())
+	if !s.start.Equal(start) {
+		s.start = start
+		s.count = 0
+	}
+
+	if !force && s.count+limit.cost() > limit.MaxAccesses {
+		return false, s.count
+	}
+
+	s.count += limit.cost()
+	return true, s.count
+}
+
+func (s *fixedWindowState) refund(limit AccessLimit, now time.Time) {
+	if s.start.Equal(now.Truncate(limit.window())) {
+		s.count = max(s.count-limit.cost(), 0)
+	}
+}
+
+func (s *fixedWindowState) usage(window time.Duration, now time.Time) Usage {
+	if !s.start.Equal(now.Truncate(window)) {
+		return Usage{}
+	}
+	return Usage{Count: s.count}
+}
+
+func (s *fixedWindowState) expiresAt(limit AccessLimit, now time.Time) time.Time {
+	return s.start.Add(limit.window())
+}
+
+type tokenBucketState struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func (s *tokenBucketState) take(limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	capacity := float64(limit.burst())
+	if s.updatedAt.IsZero() {
+		s.tokens = capacity
+		s.updatedAt = now
+	}
+
+	if elapsed := now.Sub(s.updatedAt); elapsed > 0 {
+		s.tokens = math.Min(capacity, s.tokens+float64(elapsed)/float64(limit.emissionInterval()))
+		s.updatedAt = now
+	}
+
+	// forced accesses may leave the bucket in debt
+	cost := float64(limit.cost())
+	if !force && s.tokens < cost {
+		return false, limit.burst() - int64(math.Floor(s.tokens))
+	}
+
+	s.tokens -= cost
+	return true, limit.burst() - int64(math.Floor(s.tokens))
+}
+
+func (s *tokenBucketState) refund(limit AccessLimit, now time.Time) {
+	s.tokens = math.Min(float64(limit.burst()), s.tokens+float64(limit.cost()))
+}
+
+func (s *tokenBucketState) usage(window time.Duration, now time.Time) Usage {
+	return Usage{Tokens: s.tokens}
+}
+
+func (s *tokenBucketState) expiresAt(limit AccessLimit, now time.Time) time.Time {
+	missing := float64(limit.burst()) - s.tokens
+	return s.updatedAt.Add(time.Duration(math.Ceil(missing * float64(limit.emissionInterval()))))
+}
+
+type gcraState struct {
+	tat time.Time
+}
+
+func (s *gcraState) take(limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	interval := limit.emissionInterval()
+	tat := s.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval * time.Duration(limit.cost()))
+	allowAt := newTat.Add(-interval * time.Duration(limit.burst()))
+	if !force && now.Before(allowAt) {
+		return false, gcraUsage(tat.Sub(now), interval)
+	}
+
+	s.tat = newTat
+	return true, gcraUsage(newTat.Sub(now), interval)
+}
+
+func (s *gcraState) refund(limit AccessLimit, now time.Time) {
+	s.tat = s.tat.Add(-limit.emissionInterval() * time.Duration(limit.cost()))
+	if s.tat.Before(now) {
+		s.tat = now
+	}
+}
+
+func (s *gcraState) usage(window time.Duration, now time.Time) Usage {
+	availableAt := s.tat
+	return Usage{AvailableAt: &availableAt}
+}
+
+func (s *gcraState) expiresAt(limit AccessLimit, now time.Time) time.Time {
+	return s.tat
+}
+
+func gcraUsage(ahead time.Duration, interval time.Duration) int64 {
+	return int64(math.Ceil(float64(ahead) / float64(interval)))
+}
@@ -0,0 +1,168 @@
+package storage_adapters
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	boltKeysBucket          = []byte("keys")
+	boltTokenPoliciesBucket = []byte("token_policies")
+)
+
+// BoltAdapter keeps the limits in an embedded bbolt file, so a single node
+// without network access keeps its counters and blocks across restarts.
+type BoltAdapter struct {
+	recordAdapter
+	db *bbolt.DB
+}
+
+func InitBoltAdapter(path string, clock Clock, logger *slog.Logger) (*BoltAdapter, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltKeysBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltTokenPoliciesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	adapter := &BoltAdapter{db: db}
+	adapter.recordAdapter = newRecordAdapter(adapter, clock, logger)
+	adapter.startJanitor()
+	return adapter, nil
+}
+
+func (a *BoltAdapter) Close() error {
+	a.stop()
+	return a.db.Close()
+}
+
+func boltKey(keyType string, key string) []byte {
+	return []byte(keyType + "\x00" + key)
+}
+
+func (a *BoltAdapter) updateRecord(ctx context.Context, keyType string, key string, fn func(record *keyRecord)) error {
+	return a.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltKeysBucket)
+		record := &keyRecord{KeyType: keyType, Key: key}
+		if value := bucket.Get(boltKey(keyType, key)); value != nil {
+			if err := json.Unmarshal(value, record); err != nil {
+				return err
+			}
+		}
+
+		fn(record)
+		if record.empty() {
+			return bucket.Delete(boltKey(keyType, key))
+		}
+
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltKey(keyType, key), value)
+	})
+}
+
+func (a *BoltAdapter) getRecord(ctx context.Context, keyType string, key string) (*keyRecord, error) {
+	var record *keyRecord
+	err := a.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(boltKeysBucket).Get(boltKey(keyType, key))
+		if value == nil {
+			return nil
+		}
+		record = &keyRecord{}
+		return json.Unmarshal(value, record)
+	})
+	return record, err
+}
+
+func (a *BoltAdapter) deleteRecord(ctx context.Context, keyType string, key string) error {
+	return a.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).Delete(boltKey(keyType, key))
+	})
+}
+
+func (a *BoltAdapter) listBlocks(ctx context.Context, now time.Time) ([]Block, error) {
+	blocks := []Block{}
+	err := a.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).ForEach(func(_ []byte, value []byte) error {
+			record := &keyRecord{}
+			if err := json.Unmarshal(value, record); err != nil {
+				return err
+			}
+			if blockedUntil := record.block(now); blockedUntil != nil {
+				blocks = append(blocks, Block{KeyType: record.KeyType, Key: record.Key, BlockedUntil: *blockedUntil})
+			}
+			return nil
+		})
+	})
+	return blocks, err
+}
+
+func (a *BoltAdapter) sweep(ctx context.Context, now time.Time) error {
+	return a.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltKeysBucket)
+		expired := [][]byte{}
+		err := bucket.ForEach(func(key []byte, value []byte) error {
+			record := &keyRecord{}
+			if err := json.Unmarshal(value, record); err != nil {
+				return err
+			}
+			if record.ExpiresAt <= now.UnixNano() {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (a *BoltAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	policies := map[string][]byte{}
+	err := a.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltTokenPoliciesBucket).ForEach(func(token []byte, policy []byte) error {
+			policies[string(token)] = append([]byte(nil), policy...)
+			return nil
+		})
+	})
+	return policies, err
+}
+
+func (a *BoltAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	return a.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltTokenPoliciesBucket).Put([]byte(token), policy)
+	})
+}
+
+func (a *BoltAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	deleted := false
+	err := a.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltTokenPoliciesBucket)
+		deleted = bucket.Get([]byte(token)) != nil
+		return bucket.Delete([]byte(token))
+	})
+	return deleted, err
+}
@@ -0,0 +1,71 @@
+package storage_adapters
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenABoltAdapter_WhenReopened_ThenShouldKeepTheCountersAndBlocks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rate_limiter.db")
+	limit := AccessLimit{MaxAccesses: 2, Window: time.Hour}
+
+	adapter, err := InitBoltAdapter(path, nil, nil)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+		assert.Nil(t, err)
+		assert.True(t, success)
+	}
+	_, err = adapter.AddBlock(ctx, "TOKEN", "ABC", 60000)
+	assert.Nil(t, err)
+	assert.Nil(t, adapter.Close())
+
+	adapter, err = InitBoltAdapter(path, nil, nil)
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	success, count, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Equal(t, int64(2), count)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, Block{KeyType: "TOKEN", Key: "ABC", BlockedUntil: blocks[0].BlockedUntil}, blocks[0])
+}
+
+func TestGivenEveryAlgorithm_WhenTheBoltAdapterStoresTheState_ThenShouldEnforceTheLimit(t *testing.T) {
+	ctx := context.Background()
+	adapter, err := InitBoltAdapter(filepath.Join(t.TempDir(), "rate_limiter.db"), nil, nil)
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	algorithms := []Algorithm{AlgorithmSlidingLog, AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmGCRA}
+	for _, algorithm := range algorithms {
+		limit := AccessLimit{Algorithm: algorithm, MaxAccesses: 3, Window: time.Hour}
+		admitted := 0
+		for i := 0; i < 5; i++ {
+			success, _, _, err := adapter.CheckAccess(ctx, "IP", string(algorithm), limit, 0)
+			assert.Nil(t, err)
+			if success {
+				admitted++
+			}
+		}
+		assert.Equal(t, 3, admitted, algorithm)
+
+		usage, err := adapter.GetUsage(ctx, "IP", string(algorithm))
+		assert.Nil(t, err)
+		assert.Len(t, usage, 1)
+	}
+
+	assert.Nil(t, adapter.sweep(ctx, time.Now().Add(2*time.Hour)))
+	usage, err := adapter.GetUsage(ctx, "IP", string(AlgorithmGCRA))
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+}
@@ -0,0 +1,251 @@
+package storage_adapters
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// CircuitBreakerAdapter stops calling the wrapped adapter after failureThreshold
+// consecutive errors. While open every call fails with ErrCircuitOpen; once
+// openDuration elapses a single call is let through to probe for recovery,
+// closing the circuit on success and opening it again on failure.
+type CircuitBreakerAdapter struct {
+	adapter          StorageAdapter
+	failureThreshold int64
+	openDuration     time.Duration
+	clock            Clock
+	logger           *slog.Logger
+
+	mutex     sync.Mutex
+	failures  int64
+	openUntil time.Time
+	probing   bool
+}
+
+func NewCircuitBreakerAdapter(adapter StorageAdapter, failureThreshold int64, openDuration time.Duration, clock Clock, logger *slog.Logger) *CircuitBreakerAdapter {
+	return &CircuitBreakerAdapter{
+		adapter:          adapter,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		clock:            clockOrSystem(clock),
+		logger:           loggerOrDefault(logger),
+	}
+}
+
+func (a *CircuitBreakerAdapter) allow() (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.failures < a.failureThreshold {
+		return false, nil
+	}
+	if a.probing || a.clock.Now().Before(a.openUntil) {
+		return false, ErrCircuitOpen
+	}
+
+	a.probing = true
+	return true, nil
+}
+
+func (a *CircuitBreakerAdapter) done(probe bool, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if probe {
+		a.probing = false
+	}
+
+	// a canceled request says nothing about the storage health
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err == nil {
+		if a.failures >= a.failureThreshold {
+			a.logger.Info("Storage circuit breaker closed")
+		}
+		a.failures = 0
+		return
+	}
+
+	a.failures++
+	if a.failures >= a.failureThreshold {
+		if a.failures == a.failureThreshold || probe {
+			a.logger.Warn("Storage circuit breaker open", "duration", a.openDuration, "error", err)
+		}
+		a.openUntil = a.clock.Now().Add(a.openDuration)
+	}
+}
+
+func (a *CircuitBreakerAdapter) call(fn func() error) error {
+	probe, err := a.allow()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	a.done(probe, err)
+	return err
+}
+
+func (a *CircuitBreakerAdapter) AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error) {
+	var success bool
+	var count int64
+	err := a.call(func() (err error) {
+		success, count, err = a.adapter.AddAccess(ctx, keyType, key, limit)
+		return err
+	})
+	return success, count, err
+}
+
+func (a *CircuitBreakerAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	var success bool
+	var count int64
+	var block *time.Time
+	err := a.call(func() (err error) {
+		success, count, block, err = a.adapter.CheckAccess(ctx, keyType, key, limit, blockMilliseconds)
+		return err
+	})
+	return success, count, block, err
+}
+
+func (a *CircuitBreakerAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error) {
+	var count int64
+	err := a.call(func() (err error) {
+		count, err = a.adapter.ChargeAccess(ctx, keyType, key, limit)
+		return err
+	})
+	return count, err
+}
+
+func (a *CircuitBreakerAdapter) RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error {
+	return a.call(func() error {
+		return a.adapter.RefundAccess(ctx, keyType, key, limit)
+	})
+}
+
+func (a *CircuitBreakerAdapter) AddOffense(ctx context.Context, keyType string, key string, decay time.Duration) (int64, error) {
+	var offenses int64
+	err := a.call(func() (err error) {
+		offenses, err = a.adapter.AddOffense(ctx, keyType, key, decay)
+		return err
+	})
+	return offenses, err
+}
+
+func (a *CircuitBreakerAdapter) GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error) {
+	var block *time.Time
+	err := a.call(func() (err error) {
+		block, err = a.adapter.GetBlock(ctx, keyType, key)
+		return err
+	})
+	return block, err
+}
+
+func (a *CircuitBreakerAdapter) AddBlock(ctx context.Context, keyType string, key string, milliseconds int64) (*time.Time, error) {
+	var block *time.Time
+	err := a.call(func() (err error) {
+		block, err = a.adapter.AddBlock(ctx, keyType, key, milliseconds)
+		return err
+	})
+	return block, err
+}
+
+func (a *CircuitBreakerAdapter) ListBlocks(ctx context.Context) ([]Block, error) {
+	var blocks []Block
+	err := a.call(func() (err error) {
+		blocks, err = a.adapter.ListBlocks(ctx)
+		return err
+	})
+	return blocks, err
+}
+
+func (a *CircuitBreakerAdapter) GetUsage(ctx context.Context, keyType string, key string) ([]Usage, error) {
+	var usage []Usage
+	err := a.call(func() (err error) {
+		usage, err = a.adapter.GetUsage(ctx, keyType, key)
+		return err
+	})
+	return usage, err
+}
+
+func (a *CircuitBreakerAdapter) ClearBlock(ctx context.Context, keyType string, key string) (bool, error) {
+	var cleared bool
+	err := a.call(func() (err error) {
+		cleared, err = a.adapter.ClearBlock(ctx, keyType, key)
+		return err
+	})
+	return cleared, err
+}
+
+func (a *CircuitBreakerAdapter) ResetKey(ctx context.Context, keyType string, key string) error {
+	return a.call(func() error {
+		return a.adapter.ResetKey(ctx, keyType, key)
+	})
+}
+
+func (a *CircuitBreakerAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	var policies map[string][]byte
+	err := a.call(func() (err error) {
+		policies, err = a.adapter.ListTokenPolicies(ctx)
+		return err
+	})
+	return policies, err
+}
+
+func (a *CircuitBreakerAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	return a.call(func() error {
+		return a.adapter.SetTokenPolicy(ctx, token, policy)
+	})
+}
+
+func (a *CircuitBreakerAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	var deleted bool
+	err := a.call(func() (err error) {
+		deleted, err = a.adapter.DeleteTokenPolicy(ctx, token)
+		return err
+	})
+	return deleted, err
+}
+
+// WrapConcurrency puts adapter, usually the ConcurrencyAdapter behind the
+// wrapped StorageAdapter, in the same circuit, so the failures of either one
+// open it for both.
+func (a *CircuitBreakerAdapter) WrapConcurrency(adapter ConcurrencyAdapter) ConcurrencyAdapter {
+	return &circuitBreakerConcurrencyAdapter{breaker: a, adapter: adapter}
+}
+
+type circuitBreakerConcurrencyAdapter struct {
+	breaker *CircuitBreakerAdapter
+	adapter ConcurrencyAdapter
+}
+
+func (a *circuitBreakerConcurrencyAdapter) AcquireSlot(ctx context.Context, keyType string, key string, maxSlots int64, ttl time.Duration) (string, int64, error) {
+	var lease string
+	var slots int64
+	err := a.breaker.call(func() (err error) {
+		lease, slots, err = a.adapter.AcquireSlot(ctx, keyType, key, maxSlots, ttl)
+		return err
+	})
+	return lease, slots, err
+}
+
+func (a *circuitBreakerConcurrencyAdapter) RenewSlot(ctx context.Context, keyType string, key string, lease string, ttl time.Duration) (bool, error) {
+	var renewed bool
+	err := a.breaker.call(func() (err error) {
+		renewed, err = a.adapter.RenewSlot(ctx, keyType, key, lease, ttl)
+		return err
+	})
+	return renewed, err
+}
+
+func (a *circuitBreakerConcurrencyAdapter) ReleaseSlot(ctx context.Context, keyType string, key string, lease string) error {
+	return a.breaker.call(func() error {
+		return a.adapter.ReleaseSlot(ctx, keyType, key, lease)
+	})
+}
@@ -0,0 +1,58 @@
+package storage_adapters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenARedisOutage_WhenTheFailureThresholdIsReached_ThenShouldOpenAndProbeForRecovery(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisAdapter := NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}), nil, nil)
+	clock := NewFakeClock(time.Now())
+	adapter := NewCircuitBreakerAdapter(redisAdapter, 2, time.Hour, clock, nil)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 10}
+
+	server.Close()
+	for i := 0; i < 2; i++ {
+		_, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+		assert.NotNil(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+
+	assert.Nil(t, server.Restart())
+	_, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	clock.Advance(time.Hour)
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Equal(t, int64(0), adapter.failures)
+}
+
+func TestGivenARedisOutage_WhenTheConcurrencyCallsFail_ThenShouldOpenTheCircuitOfTheStorageCallsToo(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisAdapter := NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}), nil, nil)
+	adapter := NewCircuitBreakerAdapter(redisAdapter, 2, time.Hour, NewFakeClock(time.Now()), nil)
+	concurrencyAdapter := adapter.WrapConcurrency(redisAdapter)
+	ctx := context.Background()
+
+	server.Close()
+	for i := 0; i < 2; i++ {
+		_, _, err := concurrencyAdapter.AcquireSlot(ctx, "IP", "10.0.0.1", 1, time.Minute)
+		assert.NotNil(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+
+	assert.Nil(t, server.Restart())
+	_, _, err := concurrencyAdapter.AcquireSlot(ctx, "IP", "10.0.0.1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	_, _, _, err = adapter.CheckAccess(ctx, "IP", "10.0.0.1", AccessLimit{MaxAccesses: 10}, 0)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
@@ -0,0 +1,63 @@
+package storage_adapters
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the adapters the current time, so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SystemClock Clock = systemClock{}
+
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+// FakeClock only moves when advanced. Functions registered with OnAdvance are
+// called on every Advance, e.g. to move the clock of an in-process Redis.
+type FakeClock struct {
+	mutex     sync.Mutex
+	now       time.Time
+	onAdvance []func(d time.Duration)
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	onAdvance := c.onAdvance
+	c.mutex.Unlock()
+
+	for _, fn := range onAdvance {
+		fn(d)
+	}
+}
+
+func (c *FakeClock) OnAdvance(fn func(d time.Duration)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onAdvance = append(c.onAdvance, fn)
+}
@@ -0,0 +1,30 @@
+package storage_adapters
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// ConcurrencyAdapter counts the requests of a key being served at the same
+// time. Every slot is a lease that expires after ttl unless it is renewed, so
+// the slots held by a replica that crashed are freed on their own.
+type ConcurrencyAdapter interface {
+	// AcquireSlot takes one of the maxSlots slots of the key, returning the
+	// lease to renew and release it and the slots in use. The lease is empty
+	// when every slot is taken.
+	AcquireSlot(ctx context.Context, keyType string, key string, maxSlots int64, ttl time.Duration) (string, int64, error)
+	// RenewSlot extends the lease for another ttl. It returns false when the
+	// lease already expired.
+	RenewSlot(ctx context.Context, keyType string, key string, lease string, ttl time.Duration) (bool, error)
+	ReleaseSlot(ctx context.Context, keyType string, key string, lease string) error
+}
+
+func newLease() (string, error) {
+	lease := make([]byte, 16)
+	if _, err := rand.Read(lease); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(lease), nil
+}
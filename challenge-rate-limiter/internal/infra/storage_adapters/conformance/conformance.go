@@ -0,0 +1,466 @@
+// Package conformance checks that a StorageAdapter implementation behaves like
+// the ones shipped with the rate limiter. Implementations run it from a test:
+//
+//	func TestMyAdapter(t *testing.T) {
+//		conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+//			return NewMyAdapter(clock)
+//		})
+//	}
+package conformance
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewAdapter returns an empty adapter that reads the time from clock. Adapters
+// that also keep time elsewhere, like the TTLs of a Redis server, should follow
+// the clock with FakeClock.OnAdvance.
+type NewAdapter func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter
+
+var algorithms = []storage_adapters.Algorithm{
+	storage_adapters.AlgorithmSlidingLog,
+	storage_adapters.AlgorithmFixedWindow,
+	storage_adapters.AlgorithmTokenBucket,
+	storage_adapters.AlgorithmGCRA,
+}
+
+// startTime is aligned to the minute, so fixed windows start with the test.
+var startTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func Run(t *testing.T, newAdapter NewAdapter) {
+	setup := func(t *testing.T) (storage_adapters.StorageAdapter, *storage_adapters.FakeClock) {
+		clock := storage_adapters.NewFakeClock(startTime)
+		return newAdapter(t, clock), clock
+	}
+
+	for _, algorithm := range algorithms {
+		algorithm := algorithm
+		t.Run(string(algorithm), func(t *testing.T) {
+			t.Run("AdmissionCounting", func(t *testing.T) {
+				adapter, _ := setup(t)
+				testAdmissionCounting(t, adapter, algorithm)
+			})
+			t.Run("WindowExpiry", func(t *testing.T) {
+				adapter, clock := setup(t)
+				testWindowExpiry(t, adapter, clock, algorithm)
+			})
+			t.Run("AccessCost", func(t *testing.T) {
+				adapter, _ := setup(t)
+				testAccessCost(t, adapter, algorithm)
+			})
+			t.Run("AccessRefund", func(t *testing.T) {
+				adapter, _ := setup(t)
+				testAccessRefund(t, adapter, algorithm)
+			})
+			t.Run("ConcurrentAccess", func(t *testing.T) {
+				adapter, _ := setup(t)
+				testConcurrentAccess(t, adapter, algorithm)
+			})
+		})
+	}
+
+	t.Run("BlockSetGetExpire", func(t *testing.T) {
+		adapter, clock := setup(t)
+		testBlockSetGetExpire(t, adapter, clock)
+	})
+	t.Run("BlockOnExceededLimit", func(t *testing.T) {
+		adapter, clock := setup(t)
+		testBlockOnExceededLimit(t, adapter, clock)
+	})
+	t.Run("KeyIsolation", func(t *testing.T) {
+		adapter, _ := setup(t)
+		testKeyIsolation(t, adapter)
+	})
+	t.Run("BlockAdministration", func(t *testing.T) {
+		adapter, _ := setup(t)
+		testBlockAdministration(t, adapter)
+	})
+	t.Run("BlockKeyType", func(t *testing.T) {
+		adapter, _ := setup(t)
+		testBlockKeyType(t, adapter)
+	})
+	t.Run("OffenseDecay", func(t *testing.T) {
+		adapter, clock := setup(t)
+		testOffenseDecay(t, adapter, clock)
+	})
+
+	// the concurrency limits are optional
+	setupConcurrency := func(t *testing.T) (storage_adapters.ConcurrencyAdapter, *storage_adapters.FakeClock) {
+		adapter, clock := setup(t)
+		concurrencyAdapter, ok := adapter.(storage_adapters.ConcurrencyAdapter)
+		if !ok {
+			t.Skip("the adapter isn't a ConcurrencyAdapter")
+		}
+		return concurrencyAdapter, clock
+	}
+	t.Run("SlotAcquireRelease", func(t *testing.T) {
+		adapter, _ := setupConcurrency(t)
+		testSlotAcquireRelease(t, adapter)
+	})
+	t.Run("SlotLeaseExpiry", func(t *testing.T) {
+		adapter, clock := setupConcurrency(t)
+		testSlotLeaseExpiry(t, adapter, clock)
+	})
+}
+
+func testAdmissionCounting(t *testing.T, adapter storage_adapters.StorageAdapter, algorithm storage_adapters.Algorithm) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{Algorithm: algorithm, MaxAccesses: 3, Window: time.Minute}
+
+	for i := int64(1); i <= 3; i++ {
+		success, count, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+		assert.Nil(t, err)
+		assert.True(t, success)
+		assert.Equal(t, i, count)
+		assert.Nil(t, block)
+	}
+
+	success, count, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Equal(t, int64(3), count)
+	assert.Nil(t, block)
+
+	success, _, err = adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, success)
+}
+
+func testWindowExpiry(t *testing.T, adapter storage_adapters.StorageAdapter, clock *storage_adapters.FakeClock, algorithm storage_adapters.Algorithm) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{Algorithm: algorithm, MaxAccesses: 3, Window: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	}
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+
+	clock.Advance(limit.Window)
+	for i := 0; i < 3; i++ {
+		success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+		assert.Nil(t, err)
+		assert.True(t, success)
+	}
+}
+
+func testAccessCost(t *testing.T, adapter storage_adapters.StorageAdapter, algorithm storage_adapters.Algorithm) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{Algorithm: algorithm, MaxAccesses: 5, Window: time.Minute, Cost: 3}
+
+	success, count, err := adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Equal(t, int64(3), count)
+
+	// the cost is taken at once or not at all
+	success, count, err = adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Equal(t, int64(3), count)
+
+	limit.Cost = 2
+	success, count, err = adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Equal(t, int64(5), count)
+
+	// charges go past the limit
+	count, err = adapter.ChargeAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), count)
+
+	limit.Cost = 0
+	success, _, _, err = adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+}
+
+func testAccessRefund(t *testing.T, adapter storage_adapters.StorageAdapter, algorithm storage_adapters.Algorithm) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{Algorithm: algorithm, MaxAccesses: 5, Window: time.Minute, Cost: 2}
+
+	// refunding a key without accesses is a no-op
+	assert.Nil(t, adapter.RefundAccess(ctx, "IP", "10.0.0.1", limit))
+
+	for i := 0; i < 2; i++ {
+		success, _, err := adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+		assert.Nil(t, err)
+		assert.True(t, success)
+	}
+	success, _, err := adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, success)
+
+	assert.Nil(t, adapter.RefundAccess(ctx, "IP", "10.0.0.1", limit))
+	success, count, err := adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Equal(t, int64(4), count)
+
+	success, _, err = adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, success)
+}
+
+func testConcurrentAccess(t *testing.T, adapter storage_adapters.StorageAdapter, algorithm storage_adapters.Algorithm) {
+	limit := storage_adapters.AccessLimit{Algorithm: algorithm, MaxAccesses: 10, Window: time.Hour}
+
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			success, _, _, err := adapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 0)
+			assert.Nil(t, err)
+			if success {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(10), admitted.Load())
+}
+
+func testBlockSetGetExpire(t *testing.T, adapter storage_adapters.StorageAdapter, clock *storage_adapters.FakeClock) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{MaxAccesses: 10, Window: time.Minute}
+
+	blockedUntil, err := adapter.AddBlock(ctx, "IP", "10.0.0.1", 1000)
+	assert.Nil(t, err)
+	assert.True(t, startTime.Add(time.Second).Equal(*blockedUntil))
+
+	block, err := adapter.GetBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.True(t, blockedUntil.Equal(*block))
+
+	success, _, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 1000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.True(t, blockedUntil.Equal(*block))
+
+	clock.Advance(time.Second + time.Millisecond)
+	block, err = adapter.GetBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Nil(t, block)
+
+	success, _, block, err = adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 1000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Nil(t, block)
+}
+
+func testBlockOnExceededLimit(t *testing.T, adapter storage_adapters.StorageAdapter, clock *storage_adapters.FakeClock) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 5000)
+	success, _, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 5000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.NotNil(t, block)
+	assert.True(t, startTime.Add(5*time.Second).Equal(*block))
+
+	stored, err := adapter.GetBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.True(t, block.Equal(*stored))
+
+	// a block time of zero denies the access without blocking the key
+	adapter.CheckAccess(ctx, "IP", "10.0.0.2", limit, 0)
+	success, _, block, err = adapter.CheckAccess(ctx, "IP", "10.0.0.2", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Nil(t, block)
+
+	stored, err = adapter.GetBlock(ctx, "IP", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	clock.Advance(5*time.Second + time.Millisecond)
+	stored, err = adapter.GetBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+}
+
+func testKeyIsolation(t *testing.T, adapter storage_adapters.StorageAdapter) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	adapter.CheckAccess(ctx, "IP", "ABC", limit, 5000)
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "ABC", limit, 5000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+
+	for _, keyType := range []string{"TOKEN", "IP:login"} {
+		success, _, _, err := adapter.CheckAccess(ctx, keyType, "ABC", limit, 5000)
+		assert.Nil(t, err)
+		assert.True(t, success, keyType)
+
+		block, err := adapter.GetBlock(ctx, keyType, "ABC")
+		assert.Nil(t, err)
+		assert.Nil(t, block, keyType)
+	}
+
+	success, _, _, err = adapter.CheckAccess(ctx, "IP", "DEF", limit, 5000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+}
+
+func testBlockAdministration(t *testing.T, adapter storage_adapters.StorageAdapter) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 5000)
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 5000)
+	adapter.AddBlock(ctx, "TOKEN", "ABC", 5000)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	keys := []string{}
+	for _, block := range blocks {
+		keys = append(keys, block.Key)
+	}
+	assert.ElementsMatch(t, []string{"10.0.0.1", "ABC"}, keys)
+
+	usage, err := adapter.GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Len(t, usage, 1)
+	assert.Equal(t, int64(1), usage[0].Count)
+
+	cleared, err := adapter.ClearBlock(ctx, "TOKEN", "ABC")
+	assert.Nil(t, err)
+	assert.True(t, cleared)
+	cleared, err = adapter.ClearBlock(ctx, "TOKEN", "ABC")
+	assert.Nil(t, err)
+	assert.False(t, cleared)
+
+	assert.Nil(t, adapter.ResetKey(ctx, "IP", "10.0.0.1"))
+	usage, err = adapter.GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 5000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+}
+
+// testBlockKeyType checks that blocks are listed with the key type they were
+// set with, whatever the encoding of the key type in the storage.
+func testBlockKeyType(t *testing.T, adapter storage_adapters.StorageAdapter) {
+	ctx := context.Background()
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	adapter.CheckAccess(ctx, "IP:POST /Login-Form", "10.0.0.1", limit, 5000)
+	adapter.CheckAccess(ctx, "IP:POST /Login-Form", "10.0.0.1", limit, 5000)
+	adapter.AddBlock(ctx, "API-Token", "ABC", 5000)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	keyTypes := map[string]string{}
+	for _, block := range blocks {
+		keyTypes[block.Key] = block.KeyType
+	}
+	assert.Equal(t, map[string]string{"10.0.0.1": "IP:POST /Login-Form", "ABC": "API-Token"}, keyTypes)
+
+	for _, block := range blocks {
+		cleared, err := adapter.ClearBlock(ctx, block.KeyType, block.Key)
+		assert.Nil(t, err)
+		assert.True(t, cleared, block.KeyType)
+	}
+}
+
+func testOffenseDecay(t *testing.T, adapter storage_adapters.StorageAdapter, clock *storage_adapters.FakeClock) {
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		offenses, err := adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, i, offenses)
+		clock.Advance(30 * time.Second)
+	}
+
+	offenses, err := adapter.AddOffense(ctx, "IP", "10.0.0.2", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offenses)
+
+	clock.Advance(time.Minute)
+	offenses, err = adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offenses)
+
+	adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Minute)
+	assert.Nil(t, adapter.ResetKey(ctx, "IP", "10.0.0.1"))
+	offenses, err = adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offenses)
+}
+
+func testSlotAcquireRelease(t *testing.T, adapter storage_adapters.ConcurrencyAdapter) {
+	ctx := context.Background()
+
+	first, count, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, first)
+	assert.Equal(t, int64(1), count)
+
+	second, count, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, int64(2), count)
+
+	lease, count, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, lease)
+	assert.Equal(t, int64(2), count)
+
+	lease, _, err = adapter.AcquireSlot(ctx, "IP", "10.0.0.2", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lease)
+
+	assert.Nil(t, adapter.ReleaseSlot(ctx, "IP", "10.0.0.1", first))
+	lease, count, err = adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lease)
+	assert.Equal(t, int64(2), count)
+}
+
+func testSlotLeaseExpiry(t *testing.T, adapter storage_adapters.ConcurrencyAdapter, clock *storage_adapters.FakeClock) {
+	ctx := context.Background()
+
+	lease, _, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 1, 10*time.Second)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lease)
+
+	clock.Advance(5 * time.Second)
+	renewed, err := adapter.RenewSlot(ctx, "IP", "10.0.0.1", lease, 10*time.Second)
+	assert.Nil(t, err)
+	assert.True(t, renewed)
+
+	// still held thanks to the renewal
+	clock.Advance(6 * time.Second)
+	other, _, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 1, 10*time.Second)
+	assert.Nil(t, err)
+	assert.Empty(t, other)
+
+	// a lease that isn't renewed is freed, as if its replica crashed
+	clock.Advance(5 * time.Second)
+	other, count, err := adapter.AcquireSlot(ctx, "IP", "10.0.0.1", 1, 10*time.Second)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, other)
+	assert.Equal(t, int64(1), count)
+
+	renewed, err = adapter.RenewSlot(ctx, "IP", "10.0.0.1", lease, 10*time.Second)
+	assert.Nil(t, err)
+	assert.False(t, renewed)
+}
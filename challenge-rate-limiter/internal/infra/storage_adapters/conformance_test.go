@@ -0,0 +1,93 @@
+package storage_adapters_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters/conformance"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryAdapterConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+		adapter, err := storage_adapters.InitMemoryAdapterWithConfig(storage_adapters.MemoryAdapterConfig{Clock: clock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { adapter.Close() })
+		return adapter
+	})
+}
+
+func TestRedisAdapterConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+		server := miniredis.RunT(t)
+		clock.OnAdvance(server.FastForward)
+		return storage_adapters.NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr()}), clock, nil)
+	})
+}
+
+func TestBoltAdapterConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+		adapter, err := storage_adapters.InitBoltAdapter(filepath.Join(t.TempDir(), "rate_limiter.db"), clock, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { adapter.Close() })
+		return adapter
+	})
+}
+
+// TestPostgresAdapterConformance runs against the database of
+// TEST_POSTGRES_DSN, whose rate limiter tables are emptied before each case.
+func TestPostgresAdapterConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+		adapter, err := storage_adapters.InitPostgresAdapter(dsn, clock, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { adapter.Close() })
+
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("TRUNCATE rate_limiter_keys, rate_limiter_token_policies"); err != nil {
+			t.Fatal(err)
+		}
+		return adapter
+	})
+}
+
+// TestMemcachedAdapterConformance runs against the servers of
+// TEST_MEMCACHED_ADDRESS (comma separated), flushed before each case, or
+// against a fake server when it isn't set.
+func TestMemcachedAdapterConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T, clock *storage_adapters.FakeClock) storage_adapters.StorageAdapter {
+		addresses := strings.Split(os.Getenv("TEST_MEMCACHED_ADDRESS"), ",")
+		if addresses[0] == "" {
+			addresses = []string{storage_adapters.NewFakeMemcachedAddress(t)}
+		} else if err := memcache.New(addresses...).FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		adapter, err := storage_adapters.InitMemcachedAdapter(addresses, clock, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { adapter.Close() })
+		return adapter
+	})
+}
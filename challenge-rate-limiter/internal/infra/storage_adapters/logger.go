@@ -0,0 +1,10 @@
+package storage_adapters
+
+import "log/slog"
+
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,401 @@
+package storage_adapters
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	memcachedKeyPrefix        = "rate_limiter:"
+	memcachedBlocksKey        = memcachedKeyPrefix + "blocks"
+	memcachedTokenPoliciesKey = memcachedKeyPrefix + "token_policies"
+	memcachedCASAttempts      = 20
+	// memcachedBuckets is the number of items the block index and the token
+	// policies are split into, each one under the default 1 MB item limit.
+	memcachedBuckets     = 16
+	memcachedMaxItemSize = 1 << 20
+)
+
+// MemcachedAdapter stores one item per key and updates it with
+// compare-and-swap, retrying when another replica wrote it in between.
+// Memcached can't list its keys, so the blocks are also kept in an index split
+// into memcachedBuckets items, as are the token policies.
+//
+// Memcached may evict any item. An evicted key loses its limit state, and an
+// evicted index bucket hides its blocks from ListBlocks while they are still
+// enforced. An evicted token policy bucket is written back from the last copy
+// read by the adapter, so the policies survive as long as one replica runs.
+type MemcachedAdapter struct {
+	recordAdapter
+	client *memcache.Client
+
+	mutexPolicies sync.Mutex
+	// tokenPolicies is the last copy of every policy bucket read or written.
+	tokenPolicies [memcachedBuckets]map[string][]byte
+}
+
+func InitMemcachedAdapter(addresses []string, clock Clock, logger *slog.Logger) (*MemcachedAdapter, error) {
+	client := memcache.New(addresses...)
+	if err := client.Ping(); err != nil {
+		return nil, err
+	}
+
+	adapter := &MemcachedAdapter{client: client}
+	adapter.recordAdapter = newRecordAdapter(adapter, clock, logger)
+	adapter.logger.Info("Connected to Memcached", "addresses", addresses)
+	adapter.startJanitor()
+	return adapter, nil
+}
+
+func (a *MemcachedAdapter) Close() error {
+	a.stop()
+	return a.client.Close()
+}
+
+// memcachedKey hashes the keys memcached doesn't accept: longer than 250 bytes
+// or with spaces and control characters.
+func memcachedKey(keyType string, key string) string {
+	itemKey := memcachedKeyPrefix + keyType + "-" + key
+	if len(itemKey) <= 250 && strings.IndexFunc(itemKey, func(r rune) bool { return r <= ' ' || r == 0x7f }) < 0 {
+		return itemKey
+	}
+
+	hash := sha256.Sum256([]byte(keyType + "-" + key))
+	return memcachedKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// memcachedExpiration converts an expiration in unix nanoseconds to the
+// relative seconds memcached expects, rounded up. Memcached reads values over
+// 30 days as an absolute unix time instead.
+func memcachedExpiration(expiresAt int64, now time.Time) int32 {
+	seconds := (expiresAt - now.UnixNano() + int64(time.Second) - 1) / int64(time.Second)
+	if seconds > 30*24*60*60 {
+		return int32(expiresAt/int64(time.Second) + 1)
+	}
+	return int32(max(seconds, 1))
+}
+
+// memcachedBucket returns the bucket of the index items holding value.
+func memcachedBucket(value string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(value))
+	return int(hash.Sum32() % memcachedBuckets)
+}
+
+func memcachedBucketKey(prefix string, bucket int) string {
+	return fmt.Sprintf("%s:%d", prefix, bucket)
+}
+
+// compareAndSwap runs fn over the current value of the item (nil when missing)
+// and stores the result, retrying when the item changed in between. Retries
+// wait a random time that grows with the attempts, so the writers of a hot key
+// stop colliding instead of retrying in lockstep.
+func (a *MemcachedAdapter) compareAndSwap(itemKey string, fn func(value []byte) (*memcache.Item, error)) error {
+	for attempt := 0; attempt < memcachedCASAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(attempt) * int64(time.Millisecond))))
+		}
+
+		item, err := a.client.Get(itemKey)
+		if err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+
+		var value []byte
+		if item != nil {
+			value = item.Value
+		}
+
+		newItem, err := fn(value)
+		if err != nil || newItem == nil {
+			return err
+		}
+
+		newItem.Key = itemKey
+		if item == nil {
+			err = a.client.Add(newItem)
+		} else {
+			// CompareAndSwap uses the CAS id read by Get
+			item.Value, item.Expiration = newItem.Value, newItem.Expiration
+			err = a.client.CompareAndSwap(item)
+		}
+
+		switch err {
+		case nil:
+			return nil
+		case memcache.ErrCASConflict, memcache.ErrNotStored:
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("too many concurrent updates of %s", itemKey)
+}
+
+func (a *MemcachedAdapter) updateRecord(ctx context.Context, keyType string, key string, fn func(record *keyRecord)) error {
+	var blockedBefore, blockedAfter int64
+	err := a.compareAndSwap(memcachedKey(keyType, key), func(value []byte) (*memcache.Item, error) {
+		record := &keyRecord{KeyType: keyType, Key: key}
+		if value != nil {
+			if err := json.Unmarshal(value, record); err != nil {
+				return nil, err
+			}
+		}
+
+		blockedBefore = record.BlockedUntil
+		fn(record)
+		blockedAfter = record.BlockedUntil
+
+		now := a.clock.Now()
+		if record.empty() {
+			// an expiring empty item keeps the update atomic, unlike a delete
+			return &memcache.Item{Value: []byte("{}"), Expiration: 1}, nil
+		}
+
+		newValue, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		return &memcache.Item{Value: newValue, Expiration: memcachedExpiration(record.ExpiresAt, now)}, nil
+	})
+	if err != nil {
+		a.logger.Error("Error updating key", "error", err)
+		return err
+	}
+
+	if blockedBefore != blockedAfter {
+		a.updateBlockIndex(keyType, key, blockedAfter)
+	}
+	return nil
+}
+
+func (a *MemcachedAdapter) getRecord(ctx context.Context, keyType string, key string) (*keyRecord, error) {
+	item, err := a.client.Get(memcachedKey(keyType, key))
+	if err == memcache.ErrCacheMiss {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &keyRecord{KeyType: keyType, Key: key}
+	return record, json.Unmarshal(item.Value, record)
+}
+
+func (a *MemcachedAdapter) deleteRecord(ctx context.Context, keyType string, key string) error {
+	err := a.client.Delete(memcachedKey(keyType, key))
+	if err != nil && err != memcache.ErrCacheMiss {
+		return err
+	}
+	a.updateBlockIndex(keyType, key, 0)
+	return nil
+}
+
+// updateBlockIndex sets the block of the key in the index, removing it when
+// blockedUntil is zero, and drops the expired blocks. It runs after the record
+// was written, which already enforces the block, so a failure only leaves the
+// block out of ListBlocks and is logged instead of failing the request.
+func (a *MemcachedAdapter) updateBlockIndex(keyType string, key string, blockedUntil int64) {
+	bucketKey := memcachedBucketKey(memcachedBlocksKey, memcachedBucket(keyType+"-"+key))
+	err := a.compareAndSwap(bucketKey, func(value []byte) (*memcache.Item, error) {
+		now := a.clock.Now()
+		blocks := []Block{}
+		if value != nil {
+			if err := json.Unmarshal(value, &blocks); err != nil {
+				return nil, err
+			}
+		}
+
+		index := []Block{}
+		for _, block := range blocks {
+			if block.BlockedUntil.After(now) && (block.KeyType != keyType || block.Key != key) {
+				index = append(index, block)
+			}
+		}
+		if blockedUntil > now.UnixNano() {
+			index = append(index, Block{KeyType: keyType, Key: key, BlockedUntil: time.Unix(0, blockedUntil)})
+		}
+		return newMemcachedIndexItem(index)
+	})
+	if err != nil {
+		a.logger.Error("Error updating the block index", "key_type", keyType, "error", err)
+	}
+}
+
+func (a *MemcachedAdapter) listBlocks(ctx context.Context, now time.Time) ([]Block, error) {
+	items, err := a.client.GetMulti(memcachedBucketKeys(memcachedBlocksKey))
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []Block{}
+	for _, item := range items {
+		index := []Block{}
+		if err := json.Unmarshal(item.Value, &index); err != nil {
+			return nil, err
+		}
+		for _, block := range index {
+			if block.BlockedUntil.After(now) {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// sweep only prunes the block index, memcached expires the keys by itself.
+func (a *MemcachedAdapter) sweep(ctx context.Context, now time.Time) error {
+	for _, bucketKey := range memcachedBucketKeys(memcachedBlocksKey) {
+		err := a.compareAndSwap(bucketKey, func(value []byte) (*memcache.Item, error) {
+			if value == nil {
+				return nil, nil
+			}
+
+			blocks := []Block{}
+			if err := json.Unmarshal(value, &blocks); err != nil {
+				return nil, err
+			}
+
+			index := []Block{}
+			for _, block := range blocks {
+				if block.BlockedUntil.After(now) {
+					index = append(index, block)
+				}
+			}
+			return newMemcachedIndexItem(index)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func memcachedBucketKeys(prefix string) []string {
+	keys := make([]string, memcachedBuckets)
+	for bucket := range keys {
+		keys[bucket] = memcachedBucketKey(prefix, bucket)
+	}
+	return keys
+}
+
+// newMemcachedIndexItem encodes a bucket of the block index or of the token
+// policies, which never expires.
+func newMemcachedIndexItem(value interface{}) (*memcache.Item, error) {
+	newValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(newValue) > memcachedMaxItemSize {
+		return nil, fmt.Errorf("index item of %d bytes exceeds the memcached item limit", len(newValue))
+	}
+	return &memcache.Item{Value: newValue}, nil
+}
+
+func (a *MemcachedAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	bucketKeys := memcachedBucketKeys(memcachedTokenPoliciesKey)
+	items, err := a.client.GetMulti(bucketKeys)
+	if err != nil {
+		a.logger.Error("Error listing token policies", "error", err)
+		return nil, err
+	}
+
+	policies := map[string][]byte{}
+	for bucket, bucketKey := range bucketKeys {
+		bucketPolicies := map[string][]byte{}
+		if item, ok := items[bucketKey]; ok {
+			if err := json.Unmarshal(item.Value, &bucketPolicies); err != nil {
+				return nil, err
+			}
+			a.mutexPolicies.Lock()
+			a.tokenPolicies[bucket] = bucketPolicies
+			a.mutexPolicies.Unlock()
+		} else if bucketPolicies, err = a.restoreTokenPolicies(bucket); err != nil {
+			a.logger.Error("Error restoring token policies", "error", err)
+			return nil, err
+		}
+
+		for token, policy := range bucketPolicies {
+			policies[token] = policy
+		}
+	}
+	return policies, nil
+}
+
+// restoreTokenPolicies writes back the last copy of a missing bucket, which
+// was evicted unless it was never written.
+func (a *MemcachedAdapter) restoreTokenPolicies(bucket int) (map[string][]byte, error) {
+	a.mutexPolicies.Lock()
+	evicted := len(a.tokenPolicies[bucket]) > 0
+	a.mutexPolicies.Unlock()
+	if !evicted {
+		return nil, nil
+	}
+
+	a.logger.Warn("Restoring evicted token policies", "bucket", bucket)
+	return a.updateTokenPolicies(bucket, func(policies map[string][]byte) {})
+}
+
+// updateTokenPolicies runs fn over the policies of a bucket, starting from the
+// last copy of the bucket when it was evicted, and returns the stored ones.
+func (a *MemcachedAdapter) updateTokenPolicies(bucket int, fn func(policies map[string][]byte)) (map[string][]byte, error) {
+	var policies map[string][]byte
+	err := a.compareAndSwap(memcachedBucketKey(memcachedTokenPoliciesKey, bucket), func(value []byte) (*memcache.Item, error) {
+		policies = map[string][]byte{}
+		if value != nil {
+			if err := json.Unmarshal(value, &policies); err != nil {
+				return nil, err
+			}
+		} else {
+			a.mutexPolicies.Lock()
+			for token, policy := range a.tokenPolicies[bucket] {
+				policies[token] = policy
+			}
+			a.mutexPolicies.Unlock()
+		}
+
+		fn(policies)
+		return newMemcachedIndexItem(policies)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutexPolicies.Lock()
+	a.tokenPolicies[bucket] = policies
+	a.mutexPolicies.Unlock()
+	return policies, nil
+}
+
+func (a *MemcachedAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	_, err := a.updateTokenPolicies(memcachedBucket(token), func(policies map[string][]byte) {
+		policies[token] = policy
+	})
+	if err != nil {
+		a.logger.Error("Error setting token policy", "error", err)
+	}
+	return err
+}
+
+func (a *MemcachedAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	deleted := false
+	_, err := a.updateTokenPolicies(memcachedBucket(token), func(policies map[string][]byte) {
+		_, deleted = policies[token]
+		delete(policies, token)
+	})
+	if err != nil {
+		a.logger.Error("Error deleting token policy", "error", err)
+		return false, err
+	}
+	return deleted, nil
+}
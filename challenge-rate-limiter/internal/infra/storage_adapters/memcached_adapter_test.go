@@ -0,0 +1,251 @@
+package storage_adapters
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMemcachedItem struct {
+	value []byte
+	flags string
+	cas   uint64
+}
+
+// fakeMemcached serves the subset of the memcached text protocol used by the
+// MemcachedAdapter. Items never expire, and conflict makes every write of the
+// keys it matches fail, as if another client always wrote them first.
+type fakeMemcached struct {
+	address  string
+	mutex    sync.Mutex
+	items    map[string]*fakeMemcachedItem
+	lastCAS  uint64
+	conflict func(key string) bool
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeMemcached{address: listener.Addr().String(), items: map[string]*fakeMemcachedItem{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// NewFakeMemcachedAddress starts a fakeMemcached for the tests of other
+// packages, which can't reach the unexported server.
+func NewFakeMemcachedAddress(t *testing.T) string {
+	return newFakeMemcached(t).address
+}
+
+func (s *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		var value []byte
+		if command := fields[0]; command == "set" || command == "add" || command == "cas" {
+			size, _ := strconv.Atoi(fields[4])
+			value = make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				return
+			}
+			value = value[:size]
+		}
+
+		rw.WriteString(s.handle(fields, value))
+		rw.Flush()
+	}
+}
+
+func (s *fakeMemcached) handle(fields []string, value []byte) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch fields[0] {
+	case "version":
+		return "VERSION fake\r\n"
+	case "gets":
+		reply := ""
+		for _, key := range fields[1:] {
+			if item, ok := s.items[key]; ok {
+				reply += fmt.Sprintf("VALUE %s %s %d %d\r\n%s\r\n", key, item.flags, len(item.value), item.cas, item.value)
+			}
+		}
+		return reply + "END\r\n"
+	case "set", "add", "cas":
+		item, exists := s.items[fields[1]]
+		conflict := s.conflict != nil && s.conflict(fields[1])
+		switch {
+		case fields[0] == "add" && (exists || conflict):
+			return "NOT_STORED\r\n"
+		case fields[0] == "cas" && !exists:
+			return "NOT_FOUND\r\n"
+		case fields[0] == "cas" && (fields[5] != strconv.FormatUint(item.cas, 10) || conflict):
+			return "EXISTS\r\n"
+		}
+		s.lastCAS++
+		s.items[fields[1]] = &fakeMemcachedItem{value: value, flags: fields[2], cas: s.lastCAS}
+		return "STORED\r\n"
+	case "delete":
+		if _, ok := s.items[fields[1]]; !ok {
+			return "NOT_FOUND\r\n"
+		}
+		delete(s.items, fields[1])
+		return "DELETED\r\n"
+	}
+	return "ERROR\r\n"
+}
+
+func (s *fakeMemcached) setConflict(conflict func(key string) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conflict = conflict
+}
+
+func (s *fakeMemcached) keys(prefix string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := []string{}
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// evict drops the items of the keys with the prefix.
+func (s *fakeMemcached) evict(prefix string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.items, key)
+		}
+	}
+}
+
+func newTestMemcachedAdapter(t *testing.T) (*MemcachedAdapter, *fakeMemcached) {
+	server := newFakeMemcached(t)
+	adapter, err := InitMemcachedAdapter([]string{server.address}, nil, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() { adapter.Close() })
+	return adapter, server
+}
+
+func TestGivenABlockIndexUnderContention_WhenCheckAccessBlocksTheKey_ThenShouldStillBlockIt(t *testing.T) {
+	adapter, server := newTestMemcachedAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	server.setConflict(func(key string) bool { return strings.HasPrefix(key, memcachedBlocksKey) })
+
+	success, _, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.NotNil(t, block)
+
+	block, err = adapter.GetBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.NotNil(t, block)
+}
+
+func TestGivenManyTokenPolicies_WhenSetTokenPolicy_ThenShouldSpreadThemOverBuckets(t *testing.T) {
+	adapter, server := newTestMemcachedAdapter(t)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, adapter.SetTokenPolicy(ctx, fmt.Sprintf("token-%d", i), []byte(`{"maxRequestsPerSecond":10}`)))
+	}
+	deleted, err := adapter.DeleteTokenPolicy(ctx, "token-0")
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+
+	assert.Greater(t, len(server.keys(memcachedTokenPoliciesKey)), 1)
+	policies, err := adapter.ListTokenPolicies(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, policies, 99)
+	assert.Equal(t, []byte(`{"maxRequestsPerSecond":10}`), policies["token-1"])
+}
+
+func TestGivenEvictedTokenPolicies_WhenListTokenPolicies_ThenShouldWriteThemBack(t *testing.T) {
+	adapter, server := newTestMemcachedAdapter(t)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, adapter.SetTokenPolicy(ctx, fmt.Sprintf("token-%d", i), []byte(`{}`)))
+	}
+	buckets := server.keys(memcachedTokenPoliciesKey)
+	server.evict(memcachedTokenPoliciesKey)
+
+	policies, err := adapter.ListTokenPolicies(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, policies, 20)
+	assert.ElementsMatch(t, buckets, server.keys(memcachedTokenPoliciesKey))
+
+	// a replica that never read the policies finds them too
+	other, err := InitMemcachedAdapter([]string{server.address}, nil, nil)
+	assert.Nil(t, err)
+	defer other.Close()
+	policies, err = other.ListTokenPolicies(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, policies, 20)
+}
+
+func TestGivenBlocksOfManyKeys_WhenListBlocks_ThenShouldReadEveryIndexBucket(t *testing.T) {
+	adapter, server := newTestMemcachedAdapter(t)
+	ctx := context.Background()
+
+	keys := []string{}
+	for i := 1; i <= 50; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		keys = append(keys, key)
+		_, err := adapter.AddBlock(ctx, "IP", key, 60000)
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(server.keys(memcachedBlocksKey)), 1)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	blockedKeys := []string{}
+	for _, block := range blocks {
+		blockedKeys = append(blockedKeys, block.Key)
+	}
+	assert.ElementsMatch(t, keys, blockedKeys)
+
+	assert.Nil(t, adapter.ResetKey(ctx, "IP", "10.0.0.1"))
+	blocks, err = adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 49)
+}
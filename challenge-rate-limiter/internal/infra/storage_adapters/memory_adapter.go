@@ -1,103 +1,359 @@
 package storage_adapters
 
 import (
+	"container/list"
 	"context"
 	"sync"
 	"time"
 )
 
+const (
+	defaultMemorySweepInterval = time.Minute
+	defaultMemoryShards        = 64
+)
+
+type limitKey struct {
+	algorithm Algorithm
+	window    time.Duration
+}
+
+// accessEntry holds every limit state and the offenses of a key, and its
+// position in the LRU list.
+type accessEntry struct {
+	keyType   string
+	key       string
+	states    map[limitKey]accessState
+	offenses  offenseCount
+	expiresAt time.Time
+	element   *list.Element
+}
+
+// MemoryAdapterConfig bounds the memory used by the MemoryAdapter. The janitor
+// drops expired blocks and idle keys every SweepInterval (one minute when zero)
+// and, when MaxKeys is set, the least recently used keys are evicted to make
+// room for new ones. Keys are spread over Shards independently locked shards
+// (64 when zero), each one holding up to MaxKeys / Shards keys.
+type MemoryAdapterConfig struct {
+	SweepInterval time.Duration
+	MaxKeys       int
+	Shards        int
+	// Clock defaults to the SystemClock.
+	Clock Clock
+}
+
+type MemoryAdapterStats struct {
+	Keys        int
+	Blocks      int
+	Evictions   uint64
+	Expirations uint64
+}
+
 type MemoryAdapter struct {
-	mutexAccesses sync.Mutex
-	mutexBlocks   sync.Mutex
-	accesses      map[string]*map[string]*[]*time.Time
-	blocks        map[string]*map[string]*time.Time
+	config        MemoryAdapterConfig
+	shards        []*memoryShard
+	mutexPolicies sync.RWMutex
+	tokenPolicies map[string][]byte
+	stopJanitor   chan struct{}
+	closeOnce     sync.Once
 }
 
 func InitMemoryAdapter() (*MemoryAdapter, error) {
-	return &MemoryAdapter{
-		mutexAccesses: sync.Mutex{},
-		mutexBlocks:   sync.Mutex{},
-		accesses:      map[string]*map[string]*[]*time.Time{},
-		blocks:        map[string]*map[string]*time.Time{},
-	}, nil
+	return InitMemoryAdapterWithConfig(MemoryAdapterConfig{})
 }
 
-func (s *MemoryAdapter) AddAccess(ctx context.Context, keyType string, key string, maxAccesses int64) (bool, int64, error) {
-	s.mutexAccesses.Lock()
-	defer s.mutexAccesses.Unlock()
-
-	keyTypeData, ok := s.accesses[keyType]
-	if !ok {
-		keyTypeData = &map[string]*[]*time.Time{}
-		s.accesses[keyType] = keyTypeData
+func InitMemoryAdapterWithConfig(config MemoryAdapterConfig) (*MemoryAdapter, error) {
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = defaultMemorySweepInterval
 	}
+	if config.Shards <= 0 {
+		config.Shards = defaultMemoryShards
+	}
+	config.Clock = clockOrSystem(config.Clock)
 
-	keyData, ok := (*keyTypeData)[key]
-	if !ok {
-		keyData = &[]*time.Time{}
-		(*keyTypeData)[key] = keyData
+	maxKeysPerShard := 0
+	if config.MaxKeys > 0 {
+		maxKeysPerShard = (config.MaxKeys + config.Shards - 1) / config.Shards
 	}
 
-	filteredKeyData, count := s.filterInLastSecond(keyData)
+	shards := make([]*memoryShard, config.Shards)
+	for i := range shards {
+		shards[i] = newMemoryShard(maxKeysPerShard)
+	}
 
-	if count >= maxAccesses {
-		return false, count, nil
+	adapter := &MemoryAdapter{
+		config:        config,
+		shards:        shards,
+		tokenPolicies: map[string][]byte{},
+		stopJanitor:   make(chan struct{}),
 	}
+	go adapter.janitor()
 
-	now := time.Now()
-	updatedKeyData := append(*filteredKeyData, &now)
-	(*keyTypeData)[key] = &updatedKeyData
+	return adapter, nil
+}
 
-	return true, count + 1, nil
+// Close stops the janitor goroutine.
+func (s *MemoryAdapter) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopJanitor)
+	})
+	return nil
 }
 
-func (s *MemoryAdapter) filterInLastSecond(keyData *[]*time.Time) (*[]*time.Time, int64) {
-	now := time.Now()
-	filtered := []*time.Time{}
+func (s *MemoryAdapter) janitor() {
+	ticker := time.NewTicker(s.config.SweepInterval)
+	defer ticker.Stop()
 
-	for _, value := range *keyData {
-		if now.Sub(*value).Seconds() < 1 {
-			filtered = append(filtered, value)
+	for {
+		select {
+		case <-s.stopJanitor:
+			return
+		case <-ticker.C:
+			s.sweep(s.config.Clock.Now())
 		}
 	}
+}
+
+func (s *MemoryAdapter) sweep(now time.Time) {
+	for _, shard := range s.shards {
+		shard.sweep(now)
+	}
+}
 
-	return &filtered, int64(len(filtered))
+// shard hashes the key with FNV-1a, without allocating the concatenated key.
+func (s *MemoryAdapter) shard(keyType string, key string) *memoryShard {
+	hash := uint32(2166136261)
+	for _, value := range [...]string{keyType, "-", key} {
+		for i := 0; i < len(value); i++ {
+			hash ^= uint32(value[i])
+			hash *= 16777619
+		}
+	}
+	return s.shards[hash%uint32(len(s.shards))]
 }
 
-func (s *MemoryAdapter) GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error) {
-	s.mutexBlocks.Lock()
-	defer s.mutexBlocks.Unlock()
+func (s *MemoryAdapter) Stats() MemoryAdapterStats {
+	stats := MemoryAdapterStats{}
+	for _, shard := range s.shards {
+		shard.mutexBlocks.Lock()
+		for _, keyTypeData := range shard.blocks {
+			stats.Blocks += len(*keyTypeData)
+		}
+		shard.mutexBlocks.Unlock()
+
+		shard.mutexAccesses.Lock()
+		stats.Keys += shard.recentKeys.Len()
+		shard.mutexAccesses.Unlock()
 
-	keyTypeData, ok := s.blocks[keyType]
-	if !ok {
-		return nil, nil
+		stats.Evictions += shard.evictions.Load()
+		stats.Expirations += shard.expirations.Load()
 	}
+	return stats
+}
+
+func (s *MemoryAdapter) AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	success, count := shard.addAccess(keyType, key, limit, s.config.Clock.Now(), false)
+	return success, count, nil
+}
 
-	blockedUntil, ok := (*keyTypeData)[key]
-	if !ok {
-		return nil, nil
+func (s *MemoryAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	_, count := shard.addAccess(keyType, key, limit, s.config.Clock.Now(), true)
+	return count, nil
+}
+
+func (s *MemoryAdapter) RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error {
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	shard.refundAccess(keyType, key, limit, s.config.Clock.Now())
+	return nil
+}
+
+func (s *MemoryAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexBlocks.Lock()
+	defer shard.mutexBlocks.Unlock()
+
+	now := s.config.Clock.Now()
+	if block := shard.getBlock(keyType, key, now); block != nil {
+		return false, 0, block, nil
+	}
+
+	shard.mutexAccesses.Lock()
+	success, count := shard.addAccess(keyType, key, limit, now, false)
+	shard.mutexAccesses.Unlock()
+
+	if success || blockMilliseconds <= 0 {
+		return success, count, nil, nil
 	}
 
-	if blockedUntil.After(time.Now()) {
-		return blockedUntil, nil
+	return false, count, shard.addBlock(keyType, key, blockMilliseconds, now), nil
+}
+
+func (s *MemoryAdapter) AcquireSlot(ctx context.Context, keyType string, key string, maxSlots int64, ttl time.Duration) (string, int64, error) {
+	lease, err := newLease()
+	if err != nil {
+		return "", 0, err
 	}
 
-	delete(*keyTypeData, key)
-	return nil, nil
+	shard := s.shard(keyType, key)
+	shard.mutexSlots.Lock()
+	defer shard.mutexSlots.Unlock()
+
+	acquired, count := shard.acquireSlot(memoryKey{keyType, key}, lease, maxSlots, s.config.Clock.Now(), ttl)
+	if !acquired {
+		return "", count, nil
+	}
+	return lease, count, nil
+}
+
+func (s *MemoryAdapter) RenewSlot(ctx context.Context, keyType string, key string, lease string, ttl time.Duration) (bool, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexSlots.Lock()
+	defer shard.mutexSlots.Unlock()
+
+	now := s.config.Clock.Now()
+	leases := shard.activeSlots(memoryKey{keyType, key}, now)
+	if _, ok := leases[lease]; !ok {
+		return false, nil
+	}
+	leases[lease] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryAdapter) ReleaseSlot(ctx context.Context, keyType string, key string, lease string) error {
+	shard := s.shard(keyType, key)
+	shard.mutexSlots.Lock()
+	defer shard.mutexSlots.Unlock()
+
+	delete(shard.slots[memoryKey{keyType, key}], lease)
+	shard.activeSlots(memoryKey{keyType, key}, s.config.Clock.Now())
+	return nil
+}
+
+func (s *MemoryAdapter) GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexBlocks.Lock()
+	defer shard.mutexBlocks.Unlock()
+
+	return shard.getBlock(keyType, key, s.config.Clock.Now()), nil
 }
 
 func (s *MemoryAdapter) AddBlock(ctx context.Context, keyType string, key string, milliseconds int64) (*time.Time, error) {
-	s.mutexBlocks.Lock()
-	defer s.mutexBlocks.Unlock()
+	shard := s.shard(keyType, key)
+	shard.mutexBlocks.Lock()
+	defer shard.mutexBlocks.Unlock()
 
-	keyTypeData, ok := s.blocks[keyType]
-	if !ok {
-		keyTypeData = &map[string]*time.Time{}
-		s.blocks[keyType] = keyTypeData
+	return shard.addBlock(keyType, key, milliseconds, s.config.Clock.Now()), nil
+}
+
+func (s *MemoryAdapter) ListBlocks(ctx context.Context) ([]Block, error) {
+	now := s.config.Clock.Now()
+	blocks := []Block{}
+	for _, shard := range s.shards {
+		shard.mutexBlocks.Lock()
+		for keyType, keyTypeData := range shard.blocks {
+			for key := range *keyTypeData {
+				if blockedUntil := shard.getBlock(keyType, key, now); blockedUntil != nil {
+					blocks = append(blocks, Block{KeyType: keyType, Key: key, BlockedUntil: *blockedUntil})
+				}
+			}
+		}
+		shard.mutexBlocks.Unlock()
+	}
+	return blocks, nil
+}
+
+func (s *MemoryAdapter) GetUsage(ctx context.Context, keyType string, key string) ([]Usage, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	usages := []Usage{}
+	entry := shard.getEntry(keyType, key)
+	if entry == nil {
+		return usages, nil
+	}
+
+	now := s.config.Clock.Now()
+	for stateKey, state := range entry.states {
+		usage := state.usage(stateKey.window, now)
+		usage.Algorithm = stateKey.algorithm
+		usage.WindowMilliseconds = stateKey.window.Milliseconds()
+		usages = append(usages, usage)
 	}
+	return usages, nil
+}
+
+func (s *MemoryAdapter) ClearBlock(ctx context.Context, keyType string, key string) (bool, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexBlocks.Lock()
+	defer shard.mutexBlocks.Unlock()
+
+	blockedUntil := shard.getBlock(keyType, key, s.config.Clock.Now())
+	if blockedUntil == nil {
+		return false, nil
+	}
+
+	delete(*shard.blocks[keyType], key)
+	return true, nil
+}
+
+func (s *MemoryAdapter) AddOffense(ctx context.Context, keyType string, key string, decay time.Duration) (int64, error) {
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	return shard.addOffense(keyType, key, s.config.Clock.Now(), decay), nil
+}
+
+func (s *MemoryAdapter) ResetKey(ctx context.Context, keyType string, key string) error {
+	if _, err := s.ClearBlock(ctx, keyType, key); err != nil {
+		return err
+	}
+
+	shard := s.shard(keyType, key)
+	shard.mutexAccesses.Lock()
+	defer shard.mutexAccesses.Unlock()
+
+	if entry := shard.getEntry(keyType, key); entry != nil {
+		shard.removeEntry(entry)
+	}
+	return nil
+}
+
+func (s *MemoryAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	s.mutexPolicies.RLock()
+	defer s.mutexPolicies.RUnlock()
+
+	policies := make(map[string][]byte, len(s.tokenPolicies))
+	for token, policy := range s.tokenPolicies {
+		policies[token] = policy
+	}
+	return policies, nil
+}
+
+func (s *MemoryAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	s.mutexPolicies.Lock()
+	defer s.mutexPolicies.Unlock()
+
+	s.tokenPolicies[token] = append([]byte(nil), policy...)
+	return nil
+}
 
-	blockedUntil := time.Now().Add(time.Duration(int64(time.Millisecond) * milliseconds))
-	(*keyTypeData)[key] = &blockedUntil
+func (s *MemoryAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	s.mutexPolicies.Lock()
+	defer s.mutexPolicies.Unlock()
 
-	return &blockedUntil, nil
+	_, ok := s.tokenPolicies[token]
+	delete(s.tokenPolicies, token)
+	return ok, nil
 }
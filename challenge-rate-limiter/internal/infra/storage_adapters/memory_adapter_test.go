@@ -0,0 +1,279 @@
+package storage_adapters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenAMaxKeysCap_WhenANewKeyArrives_ThenShouldEvictTheLeastRecentlyUsedKey(t *testing.T) {
+	adapter, err := InitMemoryAdapterWithConfig(MemoryAdapterConfig{MaxKeys: 2, Shards: 1})
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 10, Window: time.Hour}
+	adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	adapter.AddAccess(ctx, "IP", "10.0.0.2", limit)
+	adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	adapter.AddAccess(ctx, "IP", "10.0.0.3", limit)
+
+	stats := adapter.Stats()
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, uint64(1), stats.Evictions)
+
+	usage, err := adapter.GetUsage(ctx, "IP", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+
+	usage, err = adapter.GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), usage[0].Count)
+}
+
+func TestGivenAMaxKeysCap_WhenOffensesOfNewKeysArrive_ThenShouldEvictTheLeastRecentlyUsedKeyWithItsOffenses(t *testing.T) {
+	adapter, err := InitMemoryAdapterWithConfig(MemoryAdapterConfig{MaxKeys: 2, Shards: 1})
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 10, Window: time.Hour}
+	adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Hour)
+	adapter.AddOffense(ctx, "IP", "10.0.0.2", time.Hour)
+	adapter.AddOffense(ctx, "IP", "10.0.0.3", time.Hour)
+
+	stats := adapter.Stats()
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, uint64(1), stats.Evictions)
+
+	usage, err := adapter.GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+	offenses, err := adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offenses)
+
+	offenses, err = adapter.AddOffense(ctx, "IP", "10.0.0.3", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), offenses)
+}
+
+func TestGivenExpiredOffenses_WhenTheJanitorSweeps_ThenShouldDropTheKey(t *testing.T) {
+	adapter, err := InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	adapter.AddOffense(context.Background(), "IP", "10.0.0.1", time.Hour)
+
+	adapter.sweep(time.Now().Add(time.Minute))
+	assert.Equal(t, MemoryAdapterStats{Keys: 1}, adapter.Stats())
+
+	adapter.sweep(time.Now().Add(time.Hour + time.Second))
+	assert.Equal(t, MemoryAdapterStats{Expirations: 1}, adapter.Stats())
+}
+
+func TestGivenIdleKeysAndExpiredBlocks_WhenTheJanitorSweeps_ThenShouldDropThem(t *testing.T) {
+	adapter, err := InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	algorithms := []Algorithm{AlgorithmSlidingLog, AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmGCRA}
+	for _, algorithm := range algorithms {
+		limit := AccessLimit{Algorithm: algorithm, MaxAccesses: 10, Window: time.Minute}
+		adapter.AddAccess(ctx, "IP", string(algorithm), limit)
+	}
+	adapter.AddBlock(ctx, "IP", "10.0.0.1", 1000)
+
+	adapter.sweep(time.Now())
+	assert.Equal(t, MemoryAdapterStats{Keys: 4, Blocks: 1}, adapter.Stats())
+
+	adapter.sweep(time.Now().Add(time.Minute + time.Second))
+	assert.Equal(t, MemoryAdapterStats{Expirations: 4}, adapter.Stats())
+}
+
+func TestGivenAMemoryAdapter_WhenClosedTwice_ThenShouldNotPanic(t *testing.T) {
+	adapter, err := InitMemoryAdapterWithConfig(MemoryAdapterConfig{SweepInterval: time.Millisecond})
+	assert.Nil(t, err)
+
+	assert.Nil(t, adapter.Close())
+	assert.Nil(t, adapter.Close())
+}
+
+func TestGivenConcurrentCallers_WhenCheckAccessOnTheMemoryAdapter_ThenShouldNotExceedMaxAccesses(t *testing.T) {
+	algorithms := []Algorithm{AlgorithmSlidingLog, AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmGCRA}
+	for _, algorithm := range algorithms {
+		algorithm := algorithm
+		t.Run(string(algorithm), func(t *testing.T) {
+			adapter, err := InitMemoryAdapter()
+			assert.Nil(t, err)
+			defer adapter.Close()
+
+			limit := AccessLimit{Algorithm: algorithm, MaxAccesses: 10, Window: time.Hour}
+			var admitted atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					success, _, _, err := adapter.CheckAccess(context.Background(), "IP", fmt.Sprint(i%4), limit, 0)
+					assert.Nil(t, err)
+					if success {
+						admitted.Add(1)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			assert.Equal(t, int64(40), admitted.Load())
+		})
+	}
+}
+
+func TestGivenASlidingLog_WhenTheWindowSlides_ThenShouldReuseTheRingBuffer(t *testing.T) {
+	state := &slidingLogState{}
+	limit := AccessLimit{MaxAccesses: 3, Window: time.Second}
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		success, count := state.take(limit, now.Add(time.Duration(i)*400*time.Millisecond), false)
+		assert.True(t, success)
+		assert.LessOrEqual(t, count, int64(3))
+	}
+	assert.Len(t, state.accesses, 3)
+
+	success, count := state.take(limit, now.Add(3601*time.Millisecond), false)
+	assert.False(t, success)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestGivenASlidingLog_WhenAChargeIsForcedPastTheLimit_ThenShouldKeepAtMostMaxAccessesEntries(t *testing.T) {
+	state := &slidingLogState{}
+	limit := AccessLimit{MaxAccesses: 3, Window: time.Second}
+	now := time.Now()
+
+	success, count := state.take(AccessLimit{MaxAccesses: 3, Window: time.Second, Cost: 1000}, now, true)
+	assert.True(t, success)
+	assert.Equal(t, int64(1000), count)
+	assert.Len(t, state.accesses, 3)
+	assert.Equal(t, 3, state.count)
+
+	// the key stays at its limit for a whole window
+	success, _ = state.take(limit, now.Add(999*time.Millisecond), false)
+	assert.False(t, success)
+	success, _ = state.take(limit, now.Add(time.Second), false)
+	assert.True(t, success)
+}
+
+// go test -race -bench MemoryAdapter -run ^$ ./internal/infra/storage_adapters
+func BenchmarkMemoryAdapterCheckAccess(b *testing.B) {
+	for _, shards := range []int{1, defaultMemoryShards} {
+		shards := shards
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			adapter, err := InitMemoryAdapterWithConfig(MemoryAdapterConfig{Shards: shards})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer adapter.Close()
+
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			}
+			limit := AccessLimit{MaxAccesses: 100, Window: time.Second}
+			var workers atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// every worker walks the keys from a different offset
+				i := int(workers.Add(1)) * 97
+				for pb.Next() {
+					adapter.CheckAccess(context.Background(), "IP", keys[i%len(keys)], limit, 0)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestGivenBlockedKeys_WhenListBlocksOnTheMemoryAdapter_ThenShouldReturnTheActiveOnes(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	adapter, err := InitMemoryAdapterWithConfig(MemoryAdapterConfig{Shards: 4, Clock: clock})
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	adapter.AddBlock(ctx, "IP", "10.0.0.1", 1000)
+	adapter.AddBlock(ctx, "IP", "10.0.0.2", 5000)
+	adapter.AddBlock(ctx, "TOKEN", "ABC", 5000)
+	clock.Advance(2 * time.Second)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Block{
+		{KeyType: "IP", Key: "10.0.0.2", BlockedUntil: clock.Now().Add(3 * time.Second)},
+		{KeyType: "TOKEN", Key: "ABC", BlockedUntil: clock.Now().Add(3 * time.Second)},
+	}, blocks)
+}
+
+func TestGivenABlockedKey_WhenClearBlockOnTheMemoryAdapter_ThenShouldAdmitItAgain(t *testing.T) {
+	adapter, err := InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 10, Window: time.Minute}
+	adapter.AddBlock(ctx, "IP", "10.0.0.1", 60000)
+
+	cleared, err := adapter.ClearBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.True(t, cleared)
+
+	success, _, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+	assert.Nil(t, block)
+
+	cleared, err = adapter.ClearBlock(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.False(t, cleared)
+	cleared, err = adapter.ClearBlock(ctx, "TOKEN", "unknown")
+	assert.Nil(t, err)
+	assert.False(t, cleared)
+}
+
+func TestGivenAKeyWithState_WhenResetKeyOnTheMemoryAdapter_ThenShouldForgetItsAccessesBlockAndOffenses(t *testing.T) {
+	adapter, err := InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer adapter.Close()
+
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 1, Window: time.Minute}
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	adapter.CheckAccess(ctx, "IP", "10.0.0.1", limit, 60000)
+	adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Hour)
+	adapter.CheckAccess(ctx, "IP", "10.0.0.2", limit, 60000)
+
+	assert.Nil(t, adapter.ResetKey(ctx, "IP", "10.0.0.1"))
+	assert.Equal(t, MemoryAdapterStats{Keys: 1}, adapter.Stats())
+
+	usage, err := adapter.GetUsage(ctx, "IP", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+
+	offenses, err := adapter.AddOffense(ctx, "IP", "10.0.0.1", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offenses)
+
+	usage, err = adapter.GetUsage(ctx, "IP", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), usage[0].Count)
+
+	assert.Nil(t, adapter.ResetKey(ctx, "TOKEN", "unknown"))
+}
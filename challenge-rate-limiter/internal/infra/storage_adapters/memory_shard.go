@@ -0,0 +1,229 @@
+package storage_adapters
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memoryShard holds the keys hashed onto it behind its own locks, so requests
+// for keys of different shards never wait for each other.
+type memoryShard struct {
+	mutexAccesses sync.Mutex
+	mutexBlocks   sync.Mutex
+	mutexSlots    sync.Mutex
+	accesses      map[string]*map[string]*accessEntry
+	recentKeys    *list.List
+	blocks        map[string]*map[string]*time.Time
+	slots         map[memoryKey]map[string]time.Time
+	maxKeys       int
+	evictions     atomic.Uint64
+	expirations   atomic.Uint64
+}
+
+func newMemoryShard(maxKeys int) *memoryShard {
+	return &memoryShard{
+		accesses:   map[string]*map[string]*accessEntry{},
+		recentKeys: list.New(),
+		blocks:     map[string]*map[string]*time.Time{},
+		slots:      map[memoryKey]map[string]time.Time{},
+		maxKeys:    maxKeys,
+	}
+}
+
+// sweep drops the expired blocks and slot leases and the keys whose limit
+// states are back to their initial values and whose offenses expired.
+func (s *memoryShard) sweep(now time.Time) {
+	s.mutexSlots.Lock()
+	for slotKey := range s.slots {
+		s.activeSlots(slotKey, now)
+	}
+	s.mutexSlots.Unlock()
+
+	s.mutexBlocks.Lock()
+	for keyType, keyTypeData := range s.blocks {
+		for key := range *keyTypeData {
+			s.getBlock(keyType, key, now)
+		}
+		if len(*keyTypeData) == 0 {
+			delete(s.blocks, keyType)
+		}
+	}
+	s.mutexBlocks.Unlock()
+
+	s.mutexAccesses.Lock()
+	defer s.mutexAccesses.Unlock()
+
+	for _, keyTypeData := range s.accesses {
+		for _, entry := range *keyTypeData {
+			if !entry.expiresAt.After(now) {
+				s.removeEntry(entry)
+				s.expirations.Add(1)
+			}
+		}
+	}
+}
+
+func (s *memoryShard) addAccess(keyType string, key string, limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	entry := s.touchEntry(keyType, key)
+
+	stateKey := limitKey{algorithm: limit.algorithm(), window: limit.window()}
+	state, ok := entry.states[stateKey]
+	if !ok {
+		state = newAccessState(stateKey.algorithm)
+		entry.states[stateKey] = state
+	}
+
+	success, count := state.take(limit, now, force)
+	if expiresAt := state.expiresAt(limit, now); expiresAt.After(entry.expiresAt) {
+		entry.expiresAt = expiresAt
+	}
+	return success, count
+}
+
+func (s *memoryShard) refundAccess(keyType string, key string, limit AccessLimit, now time.Time) {
+	entry := s.getEntry(keyType, key)
+	if entry == nil {
+		return
+	}
+	if state, ok := entry.states[limitKey{algorithm: limit.algorithm(), window: limit.window()}]; ok {
+		state.refund(limit, now)
+	}
+}
+
+// touchEntry returns the entry of the key, adding it when missing, and marks it
+// as the most recently used.
+func (s *memoryShard) touchEntry(keyType string, key string) *accessEntry {
+	entry := s.getEntry(keyType, key)
+	if entry == nil {
+		entry = s.addEntry(keyType, key)
+	}
+	s.recentKeys.MoveToFront(entry.element)
+	return entry
+}
+
+func (s *memoryShard) getEntry(keyType string, key string) *accessEntry {
+	keyTypeData, ok := s.accesses[keyType]
+	if !ok {
+		return nil
+	}
+	return (*keyTypeData)[key]
+}
+
+func (s *memoryShard) addEntry(keyType string, key string) *accessEntry {
+	if s.maxKeys > 0 && s.recentKeys.Len() >= s.maxKeys {
+		s.removeEntry(s.recentKeys.Back().Value.(*accessEntry))
+		s.evictions.Add(1)
+	}
+
+	keyTypeData, ok := s.accesses[keyType]
+	if !ok {
+		keyTypeData = &map[string]*accessEntry{}
+		s.accesses[keyType] = keyTypeData
+	}
+
+	entry := &accessEntry{keyType: keyType, key: key, states: map[limitKey]accessState{}}
+	entry.element = s.recentKeys.PushFront(entry)
+	(*keyTypeData)[key] = entry
+	return entry
+}
+
+func (s *memoryShard) removeEntry(entry *accessEntry) {
+	s.recentKeys.Remove(entry.element)
+
+	keyTypeData := s.accesses[entry.keyType]
+	delete(*keyTypeData, entry.key)
+	if len(*keyTypeData) == 0 {
+		delete(s.accesses, entry.keyType)
+	}
+}
+
+func (s *memoryShard) getBlock(keyType string, key string, now time.Time) *time.Time {
+	keyTypeData, ok := s.blocks[keyType]
+	if !ok {
+		return nil
+	}
+
+	blockedUntil, ok := (*keyTypeData)[key]
+	if !ok {
+		return nil
+	}
+
+	if blockedUntil.After(now) {
+		return blockedUntil
+	}
+
+	delete(*keyTypeData, key)
+	return nil
+}
+
+func (s *memoryShard) addBlock(keyType string, key string, milliseconds int64, now time.Time) *time.Time {
+	keyTypeData, ok := s.blocks[keyType]
+	if !ok {
+		keyTypeData = &map[string]*time.Time{}
+		s.blocks[keyType] = keyTypeData
+	}
+
+	blockedUntil := now.Add(time.Duration(int64(time.Millisecond) * milliseconds))
+	(*keyTypeData)[key] = &blockedUntil
+
+	return &blockedUntil
+}
+
+type memoryKey struct {
+	keyType string
+	key     string
+}
+
+type offenseCount struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// addOffense counts one more offense of the key, forgetting the previous ones
+// after decay. The offenses live in the entry of the key, so they count toward
+// MaxKeys and are evicted with its limit states. s.mutexAccesses must be held.
+func (s *memoryShard) addOffense(keyType string, key string, now time.Time, decay time.Duration) int64 {
+	entry := s.touchEntry(keyType, key)
+	offenses := &entry.offenses
+	if !offenses.expiresAt.After(now) {
+		offenses.count = 0
+	}
+	offenses.count++
+	offenses.expiresAt = now.Add(decay)
+	if offenses.expiresAt.After(entry.expiresAt) {
+		entry.expiresAt = offenses.expiresAt
+	}
+	return offenses.count
+}
+
+// activeSlots drops the expired leases of the key and returns the others with
+// their expiration. s.mutexSlots must be held.
+func (s *memoryShard) activeSlots(slotKey memoryKey, now time.Time) map[string]time.Time {
+	leases := s.slots[slotKey]
+	for lease, expiresAt := range leases {
+		if !expiresAt.After(now) {
+			delete(leases, lease)
+		}
+	}
+	if len(leases) == 0 {
+		delete(s.slots, slotKey)
+		return nil
+	}
+	return leases
+}
+
+func (s *memoryShard) acquireSlot(slotKey memoryKey, lease string, maxSlots int64, now time.Time, ttl time.Duration) (bool, int64) {
+	leases := s.activeSlots(slotKey, now)
+	if int64(len(leases)) >= maxSlots {
+		return false, int64(len(leases))
+	}
+
+	if leases == nil {
+		leases = map[string]time.Time{}
+		s.slots[slotKey] = leases
+	}
+	leases[lease] = now.Add(ttl)
+	return true, int64(len(leases))
+}
@@ -0,0 +1,195 @@
+package storage_adapters
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS rate_limiter_keys (
+	key_type      TEXT   NOT NULL,
+	key           TEXT   NOT NULL,
+	record        JSONB  NOT NULL,
+	blocked_until BIGINT NOT NULL DEFAULT 0,
+	expires_at    BIGINT NOT NULL,
+	PRIMARY KEY (key_type, key)
+);
+CREATE INDEX IF NOT EXISTS rate_limiter_keys_blocked_until ON rate_limiter_keys (blocked_until) WHERE blocked_until > 0;
+CREATE INDEX IF NOT EXISTS rate_limiter_keys_expires_at ON rate_limiter_keys (expires_at);
+CREATE TABLE IF NOT EXISTS rate_limiter_token_policies (
+	token  TEXT  PRIMARY KEY,
+	policy BYTEA NOT NULL
+);
+`
+
+// PostgresAdapter stores one row per key. Updates take a transaction level
+// advisory lock on the key, so concurrent replicas serialize even before the
+// row exists, and write the row back with an upsert.
+type PostgresAdapter struct {
+	recordAdapter
+	db *sql.DB
+}
+
+func InitPostgresAdapter(dsn string, clock Clock, logger *slog.Logger) (*PostgresAdapter, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	adapter := &PostgresAdapter{db: db}
+	adapter.recordAdapter = newRecordAdapter(adapter, clock, logger)
+	adapter.logger.Info("Connected to Postgres")
+	adapter.startJanitor()
+	return adapter, nil
+}
+
+func (a *PostgresAdapter) Close() error {
+	a.stop()
+	return a.db.Close()
+}
+
+func (a *PostgresAdapter) updateRecord(ctx context.Context, keyType string, key string, fn func(record *keyRecord)) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1 || '-' || $2, 0))", keyType, key); err != nil {
+		return err
+	}
+
+	record, err := a.selectRecord(ctx, tx, keyType, key)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		record = &keyRecord{KeyType: keyType, Key: key}
+	}
+
+	fn(record)
+	if record.empty() {
+		_, err = tx.ExecContext(ctx, "DELETE FROM rate_limiter_keys WHERE key_type = $1 AND key = $2", keyType, key)
+	} else {
+		var value []byte
+		value, err = json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO rate_limiter_keys (key_type, key, record, blocked_until, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (key_type, key) DO UPDATE
+			SET record = EXCLUDED.record, blocked_until = EXCLUDED.blocked_until, expires_at = EXCLUDED.expires_at`,
+			keyType, key, value, record.BlockedUntil, record.ExpiresAt)
+	}
+	if err != nil {
+		a.logger.Error("Error updating key", "error", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (a *PostgresAdapter) selectRecord(ctx context.Context, querier rowQuerier, keyType string, key string) (*keyRecord, error) {
+	var value []byte
+	err := querier.QueryRowContext(ctx, "SELECT record FROM rate_limiter_keys WHERE key_type = $1 AND key = $2", keyType, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &keyRecord{}
+	return record, json.Unmarshal(value, record)
+}
+
+func (a *PostgresAdapter) getRecord(ctx context.Context, keyType string, key string) (*keyRecord, error) {
+	return a.selectRecord(ctx, a.db, keyType, key)
+}
+
+func (a *PostgresAdapter) deleteRecord(ctx context.Context, keyType string, key string) error {
+	_, err := a.db.ExecContext(ctx, "DELETE FROM rate_limiter_keys WHERE key_type = $1 AND key = $2", keyType, key)
+	return err
+}
+
+func (a *PostgresAdapter) listBlocks(ctx context.Context, now time.Time) ([]Block, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT key_type, key, blocked_until FROM rate_limiter_keys WHERE blocked_until > $1", now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []Block{}
+	for rows.Next() {
+		var block Block
+		var blockedUntil int64
+		if err := rows.Scan(&block.KeyType, &block.Key, &blockedUntil); err != nil {
+			return nil, err
+		}
+		block.BlockedUntil = time.Unix(0, blockedUntil)
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+func (a *PostgresAdapter) sweep(ctx context.Context, now time.Time) error {
+	_, err := a.db.ExecContext(ctx, "DELETE FROM rate_limiter_keys WHERE expires_at <= $1", now.UnixNano())
+	return err
+}
+
+func (a *PostgresAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT token, policy FROM rate_limiter_token_policies")
+	if err != nil {
+		a.logger.Error("Error listing token policies", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := map[string][]byte{}
+	for rows.Next() {
+		var token string
+		var policy []byte
+		if err := rows.Scan(&token, &policy); err != nil {
+			return nil, err
+		}
+		policies[token] = policy
+	}
+	return policies, rows.Err()
+}
+
+func (a *PostgresAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO rate_limiter_token_policies (token, policy) VALUES ($1, $2)
+		ON CONFLICT (token) DO UPDATE SET policy = EXCLUDED.policy`, token, policy)
+	if err != nil {
+		a.logger.Error("Error setting token policy", "error", err)
+	}
+	return err
+}
+
+func (a *PostgresAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	result, err := a.db.ExecContext(ctx, "DELETE FROM rate_limiter_token_policies WHERE token = $1", token)
+	if err != nil {
+		a.logger.Error("Error deleting token policy", "error", err)
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
@@ -0,0 +1,311 @@
+package storage_adapters
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultRecordSweepInterval = time.Minute
+
+// keyRecord is the state of a key as stored by the adapters that keep one
+// document per key (Postgres, Memcached and bbolt). The limit algorithms run in
+// Go over the decoded record, inside the transaction or CAS loop of the store.
+// Times are stored in unix nanoseconds.
+type keyRecord struct {
+	KeyType      string                  `json:"keyType"`
+	Key          string                  `json:"key"`
+	BlockedUntil int64                   `json:"blockedUntil,omitempty"`
+	ExpiresAt    int64                   `json:"expiresAt"`
+	States       map[string]*stateRecord `json:"states,omitempty"`
+	// Offenses are forgotten at OffensesExpireAt.
+	Offenses         int64 `json:"offenses,omitempty"`
+	OffensesExpireAt int64 `json:"offensesExpireAt,omitempty"`
+}
+
+type stateRecord struct {
+	Algorithm Algorithm `json:"algorithm"`
+	Window    int64     `json:"window"`
+	Accesses  []int64   `json:"accesses,omitempty"`
+	Start     int64     `json:"start,omitempty"`
+	Count     int64     `json:"count,omitempty"`
+	Tokens    float64   `json:"tokens,omitempty"`
+	UpdatedAt int64     `json:"updatedAt,omitempty"`
+	TAT       int64     `json:"tat,omitempty"`
+}
+
+// recordStore is implemented by every adapter built on keyRecords.
+type recordStore interface {
+	// updateRecord runs fn over the record of the key as one atomic step. The
+	// record is empty when the key is unknown, and deleted when fn leaves it
+	// with no state and no block.
+	updateRecord(ctx context.Context, keyType string, key string, fn func(record *keyRecord)) error
+	// getRecord returns nil when the key is unknown.
+	getRecord(ctx context.Context, keyType string, key string) (*keyRecord, error)
+	deleteRecord(ctx context.Context, keyType string, key string) error
+	listBlocks(ctx context.Context, now time.Time) ([]Block, error)
+	// sweep removes the records expired before now.
+	sweep(ctx context.Context, now time.Time) error
+}
+
+// recordAdapter implements the access and block methods of the StorageAdapter
+// on top of a recordStore, and sweeps its expired records in background.
+type recordAdapter struct {
+	store         recordStore
+	clock         Clock
+	logger        *slog.Logger
+	stopJanitor   chan struct{}
+	closeOnce     sync.Once
+	sweepInterval time.Duration
+}
+
+func newRecordAdapter(store recordStore, clock Clock, logger *slog.Logger) recordAdapter {
+	return recordAdapter{
+		store:         store,
+		clock:         clockOrSystem(clock),
+		logger:        loggerOrDefault(logger),
+		stopJanitor:   make(chan struct{}),
+		sweepInterval: defaultRecordSweepInterval,
+	}
+}
+
+func (a *recordAdapter) startJanitor() {
+	go func() {
+		ticker := time.NewTicker(a.sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.stopJanitor:
+				return
+			case <-ticker.C:
+				if err := a.store.sweep(context.Background(), a.clock.Now()); err != nil {
+					a.logger.Error("Error sweeping expired keys", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (a *recordAdapter) stop() {
+	a.closeOnce.Do(func() {
+		close(a.stopJanitor)
+	})
+}
+
+func (a *recordAdapter) AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error) {
+	success, count, _, err := a.CheckAccess(ctx, keyType, key, limit, -1)
+	return success, count, err
+}
+
+func (a *recordAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	var success bool
+	var count int64
+	var block *time.Time
+	err := a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		success, count, block = record.checkAccess(limit, blockMilliseconds, a.clock.Now())
+	})
+	if err != nil {
+		return false, 0, nil, err
+	}
+	return success, count, block, nil
+}
+
+func (a *recordAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error) {
+	var count int64
+	err := a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		_, count = record.take(limit, a.clock.Now(), true)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (a *recordAdapter) RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error {
+	return a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		record.refund(limit, a.clock.Now())
+	})
+}
+
+func (a *recordAdapter) GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error) {
+	record, err := a.store.getRecord(ctx, keyType, key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return record.block(a.clock.Now()), nil
+}
+
+func (a *recordAdapter) AddBlock(ctx context.Context, keyType string, key string, milliseconds int64) (*time.Time, error) {
+	var block *time.Time
+	err := a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		block = record.addBlock(milliseconds, a.clock.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (a *recordAdapter) ListBlocks(ctx context.Context) ([]Block, error) {
+	return a.store.listBlocks(ctx, a.clock.Now())
+}
+
+func (a *recordAdapter) GetUsage(ctx context.Context, keyType string, key string) ([]Usage, error) {
+	usages := []Usage{}
+	record, err := a.store.getRecord(ctx, keyType, key)
+	if err != nil || record == nil {
+		return usages, err
+	}
+
+	now := a.clock.Now()
+	for _, stateRecord := range record.States {
+		window := time.Duration(stateRecord.Window)
+		usage := stateRecord.accessState().usage(window, now)
+		usage.Algorithm = stateRecord.Algorithm
+		usage.WindowMilliseconds = window.Milliseconds()
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func (a *recordAdapter) ClearBlock(ctx context.Context, keyType string, key string) (bool, error) {
+	cleared := false
+	err := a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		cleared = record.block(a.clock.Now()) != nil
+		record.BlockedUntil = 0
+	})
+	return cleared, err
+}
+
+func (a *recordAdapter) AddOffense(ctx context.Context, keyType string, key string, decay time.Duration) (int64, error) {
+	var offenses int64
+	err := a.store.updateRecord(ctx, keyType, key, func(record *keyRecord) {
+		offenses = record.addOffense(a.clock.Now(), decay)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return offenses, nil
+}
+
+func (a *recordAdapter) ResetKey(ctx context.Context, keyType string, key string) error {
+	return a.store.deleteRecord(ctx, keyType, key)
+}
+
+func (r *keyRecord) checkAccess(limit AccessLimit, blockMilliseconds int64, now time.Time) (bool, int64, *time.Time) {
+	if blockMilliseconds >= 0 {
+		if block := r.block(now); block != nil {
+			return false, 0, block
+		}
+	}
+
+	success, count := r.take(limit, now, false)
+	if success || blockMilliseconds <= 0 {
+		return success, count, nil
+	}
+	return false, count, r.addBlock(blockMilliseconds, now)
+}
+
+func (r *keyRecord) take(limit AccessLimit, now time.Time, force bool) (bool, int64) {
+	stateKey := fmt.Sprintf("%s_%d", limit.algorithm(), limit.window().Milliseconds())
+	state := newAccessState(limit.algorithm())
+	if stateRecord, ok := r.States[stateKey]; ok {
+		state = stateRecord.accessState()
+	}
+
+	success, count := state.take(limit, now, force)
+	if r.States == nil {
+		r.States = map[string]*stateRecord{}
+	}
+	r.States[stateKey] = newStateRecord(limit, state)
+	r.ExpiresAt = max(r.ExpiresAt, state.expiresAt(limit, now).UnixNano())
+	return success, count
+}
+
+func (r *keyRecord) refund(limit AccessLimit, now time.Time) {
+	stateKey := fmt.Sprintf("%s_%d", limit.algorithm(), limit.window().Milliseconds())
+	stateRecord, ok := r.States[stateKey]
+	if !ok {
+		return
+	}
+
+	state := stateRecord.accessState()
+	state.refund(limit, now)
+	r.States[stateKey] = newStateRecord(limit, state)
+}
+
+func (r *keyRecord) block(now time.Time) *time.Time {
+	if r.BlockedUntil <= now.UnixNano() {
+		return nil
+	}
+	blockedUntil := time.Unix(0, r.BlockedUntil)
+	return &blockedUntil
+}
+
+func (r *keyRecord) addBlock(milliseconds int64, now time.Time) *time.Time {
+	blockedUntil := now.Add(time.Duration(milliseconds) * time.Millisecond)
+	r.BlockedUntil = blockedUntil.UnixNano()
+	r.ExpiresAt = max(r.ExpiresAt, r.BlockedUntil)
+	return &blockedUntil
+}
+
+func (r *keyRecord) addOffense(now time.Time, decay time.Duration) int64 {
+	if r.OffensesExpireAt <= now.UnixNano() {
+		r.Offenses = 0
+	}
+	r.Offenses++
+	r.OffensesExpireAt = now.Add(decay).UnixNano()
+	r.ExpiresAt = max(r.ExpiresAt, r.OffensesExpireAt)
+	return r.Offenses
+}
+
+func (r *keyRecord) empty() bool {
+	return len(r.States) == 0 && r.BlockedUntil == 0 && r.Offenses == 0
+}
+
+func newStateRecord(limit AccessLimit, state accessState) *stateRecord {
+	record := &stateRecord{Algorithm: limit.algorithm(), Window: int64(limit.window())}
+	switch state := state.(type) {
+	case *slidingLogState:
+		record.Accesses = make([]int64, state.count)
+		for i := range record.Accesses {
+			record.Accesses[i] = state.accesses[(state.head+i)%len(state.accesses)]
+		}
+	case *fixedWindowState:
+		record.Start, record.Count = unixNano(state.start), state.count
+	case *tokenBucketState:
+		record.Tokens, record.UpdatedAt = state.tokens, unixNano(state.updatedAt)
+	case *gcraState:
+		record.TAT = unixNano(state.tat)
+	}
+	return record
+}
+
+func (r *stateRecord) accessState() accessState {
+	switch r.Algorithm {
+	case AlgorithmFixedWindow:
+		return &fixedWindowState{start: fromUnixNano(r.Start), count: r.Count}
+	case AlgorithmTokenBucket:
+		return &tokenBucketState{tokens: r.Tokens, updatedAt: fromUnixNano(r.UpdatedAt)}
+	case AlgorithmGCRA:
+		return &gcraState{tat: fromUnixNano(r.TAT)}
+	}
+	return &slidingLogState{accesses: r.Accesses, count: len(r.Accesses)}
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, value)
+}
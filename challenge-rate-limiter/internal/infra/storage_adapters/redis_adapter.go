@@ -2,64 +2,202 @@ package storage_adapters
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 type RedisAdapter struct {
-	client *redis.Client
+	client redis.UniversalClient
+	clock  Clock
+	logger *slog.Logger
+}
+
+// RedisConfig selects the Redis deployment: a Sentinel managed master when
+// MasterName is set, a Cluster when more than one address is given and a
+// single node otherwise.
+type RedisConfig struct {
+	Addresses  []string
+	MasterName string
+	Username   string
+	Password   string
+	DB         int
+	TLS        bool
+	// Clock defaults to the SystemClock and Logger to slog.Default().
+	Clock  Clock
+	Logger *slog.Logger
 }
 
 func InitRedisAdapter(addr string) (*RedisAdapter, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+	return InitRedisAdapterWithConfig(RedisConfig{Addresses: []string{addr}})
+}
 
+func InitRedisAdapterWithConfig(config RedisConfig) (*RedisAdapter, error) {
+	options := &redis.UniversalOptions{
+		Addrs:      config.Addresses,
+		MasterName: config.MasterName,
+		Username:   config.Username,
+		Password:   config.Password,
+		DB:         config.DB,
+	}
+	if config.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	client := redis.NewUniversalClient(options)
 	_, err := client.Ping(context.Background()).Result()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("Connected to Redis")
+	adapter := NewRedisAdapter(client, config.Clock, config.Logger)
+	adapter.logger.Info("Connected to Redis", "addresses", config.Addresses)
+
+	return adapter, nil
+}
 
+func NewRedisAdapter(client redis.UniversalClient, clock Clock, logger *slog.Logger) *RedisAdapter {
 	return &RedisAdapter{
 		client: client,
-	}, nil
+		clock:  clockOrSystem(clock),
+		logger: loggerOrDefault(logger),
+	}
+}
+
+func (a *RedisAdapter) AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error) {
+	success, count, _, err := a.runAccessScript(ctx, keyType, key, limit, -1, false)
+	return success, count, err
 }
 
-func (a *RedisAdapter) AddAccess(ctx context.Context, keyType string, key string, maxAccesses int64) (bool, int64, error) {
-	now := time.Now()
-	clearBefore := now.Add(-time.Second)
-	pipeline := a.client.Pipeline()
+func (a *RedisAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	return a.runAccessScript(ctx, keyType, key, limit, blockMilliseconds, false)
+}
+
+func (a *RedisAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error) {
+	_, count, _, err := a.runAccessScript(ctx, keyType, key, limit, -1, true)
+	return count, err
+}
 
-	redisKey := a.customRedisKey("access", keyType, key)
-	pipeline.ZRemRangeByScore(ctx, redisKey, "0", strconv.FormatInt(clearBefore.UnixMicro(), 10))
-	count := pipeline.ZCard(ctx, redisKey)
+func (a *RedisAdapter) runAccessScript(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64, force bool) (bool, int64, *time.Time, error) {
+	now := a.clock.Now()
+	blockedUntil := now.Add(time.Duration(blockMilliseconds) * time.Millisecond)
+	forceArg := 0
+	if force {
+		forceArg = 1
+	}
+	args := []interface{}{blockMilliseconds, blockValue(blockedUntil, keyType), now.UnixMicro(), limit.cost(), forceArg}
 
-	_, err := pipeline.Exec(ctx)
+	var script *redis.Script
+	switch limit.algorithm() {
+	case AlgorithmFixedWindow:
+		script = fixedWindowScript
+		args = append(args, now.Truncate(limit.window()).UnixMicro(), limit.MaxAccesses, limit.window().Milliseconds())
+	case AlgorithmTokenBucket:
+		script = tokenBucketScript
+		args = append(args, limit.burst(), limit.emissionInterval().Microseconds())
+	case AlgorithmGCRA:
+		script = gcraScript
+		args = append(args, limit.emissionInterval().Microseconds(), limit.burst())
+	default:
+		script = slidingLogScript
+		args = append(args, limit.MaxAccesses, limit.window().Microseconds())
+	}
+
+	keys := []string{a.customRedisKey("block", keyType, key), a.stateRedisKey(keyType, key, limit)}
+	result, err := script.Run(ctx, a.client, keys, args...).Slice()
 	if err != nil {
-		fmt.Println("Error on pipeline exec", err)
-		return false, 0, err
+		a.logger.Error("Error on script run", "error", err)
+		return false, 0, nil, err
+	}
+
+	success, count := result[0].(int64) == 1, result[1].(int64)
+	if result[2] == nil {
+		return success, count, nil, nil
 	}
 
-	if count.Val() >= maxAccesses {
-		return false, count.Val(), nil
+	block, err := parseBlockTime(result[2].(string))
+	if err != nil {
+		return false, 0, nil, err
 	}
 
-	pipeline = a.client.Pipeline()
-	pipeline.ZAdd(ctx, redisKey, redis.Z{Score: float64(now.UnixMicro()), Member: now.Format(time.RFC3339Nano)})
-	pipeline.Expire(ctx, redisKey, time.Second)
+	return success, count, block, nil
+}
+
+func (a *RedisAdapter) RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error {
+	now := a.clock.Now()
+	args := []interface{}{string(limit.algorithm()), limit.cost(), now.UnixMicro()}
+	switch limit.algorithm() {
+	case AlgorithmFixedWindow:
+		args = append(args, now.Truncate(limit.window()).UnixMicro())
+	case AlgorithmTokenBucket:
+		args = append(args, limit.burst())
+	case AlgorithmGCRA:
+		args = append(args, limit.emissionInterval().Microseconds())
+	default:
+		args = append(args, 0)
+	}
 
-	_, err = pipeline.Exec(ctx)
+	return refundScript.Run(ctx, a.client, []string{a.stateRedisKey(keyType, key, limit)}, args...).Err()
+}
+
+// stateRedisKey is the key of the state kept by the algorithm of the limit.
+func (a *RedisAdapter) stateRedisKey(keyType string, key string, limit AccessLimit) string {
+	switch limit.algorithm() {
+	case AlgorithmFixedWindow:
+		return a.limitRedisKey("window", keyType, key, limit)
+	case AlgorithmTokenBucket:
+		return a.limitRedisKey("bucket", keyType, key, limit)
+	case AlgorithmGCRA:
+		return a.limitRedisKey("gcra", keyType, key, limit)
+	}
+	return a.limitRedisKey("access", keyType, key, limit)
+}
+
+func (a *RedisAdapter) AcquireSlot(ctx context.Context, keyType string, key string, maxSlots int64, ttl time.Duration) (string, int64, error) {
+	lease, err := newLease()
 	if err != nil {
-		fmt.Println("Error on pipeline exec", err)
-		return false, 0, err
+		return "", 0, err
 	}
 
-	return true, count.Val() + 1, nil
+	keys := []string{a.customRedisKey("slots", keyType, key)}
+	result, err := acquireSlotScript.Run(ctx, a.client, keys, a.slotArgs(lease, ttl, maxSlots)...).Int64Slice()
+	if err != nil {
+		a.logger.Error("Error acquiring slot", "error", err)
+		return "", 0, err
+	}
+
+	if result[0] != 1 {
+		return "", result[1], nil
+	}
+	return lease, result[1], nil
+}
+
+func (a *RedisAdapter) RenewSlot(ctx context.Context, keyType string, key string, lease string, ttl time.Duration) (bool, error) {
+	keys := []string{a.customRedisKey("slots", keyType, key)}
+	renewed, err := renewSlotScript.Run(ctx, a.client, keys, a.slotArgs(lease, ttl)...).Int64()
+	if err != nil {
+		a.logger.Error("Error renewing slot", "error", err)
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+func (a *RedisAdapter) ReleaseSlot(ctx context.Context, keyType string, key string, lease string) error {
+	if err := a.client.ZRem(ctx, a.customRedisKey("slots", keyType, key), lease).Err(); err != nil {
+		a.logger.Error("Error releasing slot", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (a *RedisAdapter) slotArgs(lease string, ttl time.Duration, args ...interface{}) []interface{} {
+	now := a.clock.Now()
+	return append([]interface{}{now.UnixMicro(), now.Add(ttl).UnixMicro(), lease, max(ttl.Milliseconds(), 1)}, args...)
 }
 
 func (a *RedisAdapter) GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error) {
@@ -72,9 +210,21 @@ func (a *RedisAdapter) GetBlock(ctx context.Context, keyType string, key string)
 		return nil, err
 	}
 
-	blockTimeInt, err := strconv.ParseInt(blockTime, 10, 64)
+	return parseBlockTime(blockTime)
+}
+
+// blockValue is the value of a block key: the block expiration in unix
+// nanoseconds and the key type, which the key only keeps in lower case.
+func blockValue(blockedUntil time.Time, keyType string) string {
+	return strconv.FormatInt(blockedUntil.UnixNano(), 10) + ":" + keyType
+}
+
+// parseBlockTime reads the expiration of a block value. Blocks set before the
+// key type was stored hold only the expiration.
+func parseBlockTime(value string) (*time.Time, error) {
+	value, _, _ = strings.Cut(value, ":")
+	blockTimeInt, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		fmt.Println("Error parsing block time", err)
 		return nil, err
 	}
 
@@ -84,19 +234,252 @@ func (a *RedisAdapter) GetBlock(ctx context.Context, keyType string, key string)
 
 func (a *RedisAdapter) AddBlock(ctx context.Context, keyType string, key string, blockTimeMilliseconds int64) (*time.Time, error) {
 	redisKey := a.customRedisKey("block", keyType, key)
-	blockTime := time.Now().Add(time.Duration(blockTimeMilliseconds) * time.Millisecond)
-	err := a.client.Set(ctx, redisKey, blockTime.UnixNano(), time.Duration(blockTimeMilliseconds)*time.Millisecond).Err()
+	blockTime := a.clock.Now().Add(time.Duration(blockTimeMilliseconds) * time.Millisecond)
+	err := a.client.Set(ctx, redisKey, blockValue(blockTime, keyType), time.Duration(blockTimeMilliseconds)*time.Millisecond).Err()
 	if err != nil {
-		fmt.Println("Error setting block", err)
+		a.logger.Error("Error setting block", "error", err)
 		return nil, err
 	}
 
 	return &blockTime, nil
 }
 
+// ListBlocks walks the block-* key space with SCAN, so it doesn't hold the
+// server like KEYS would.
+func (a *RedisAdapter) ListBlocks(ctx context.Context) ([]Block, error) {
+	redisKeys, err := a.scanKeys(ctx, "block-*")
+	if err != nil {
+		a.logger.Error("Error scanning blocks", "error", err)
+		return nil, err
+	}
+
+	blocks := []Block{}
+	for _, redisKey := range redisKeys {
+		keyType, key, found := strings.Cut(strings.TrimPrefix(redisKey, "block-{"), "-")
+		key, hasTag := strings.CutSuffix(key, "}")
+		if !found || !hasTag {
+			continue
+		}
+
+		value, err := a.client.Get(ctx, redisKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		blockedUntil, err := parseBlockTime(value)
+		if err != nil {
+			return nil, err
+		}
+		// the key only keeps the key type in lower case
+		if _, storedKeyType, ok := strings.Cut(value, ":"); ok {
+			keyType = storedKeyType
+		}
+		blocks = append(blocks, Block{KeyType: keyType, Key: key, BlockedUntil: *blockedUntil})
+	}
+	return blocks, nil
+}
+
+var limitRedisKeyPrefixes = map[string]Algorithm{
+	"access": AlgorithmSlidingLog,
+	"window": AlgorithmFixedWindow,
+	"bucket": AlgorithmTokenBucket,
+	"gcra":   AlgorithmGCRA,
+}
+
+func (a *RedisAdapter) GetUsage(ctx context.Context, keyType string, key string) ([]Usage, error) {
+	redisKeys, err := a.limitRedisKeys(ctx, keyType, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.clock.Now()
+	usages := []Usage{}
+	for redisKey, usage := range redisKeys {
+		window := time.Duration(usage.WindowMilliseconds) * time.Millisecond
+		switch usage.Algorithm {
+		case AlgorithmFixedWindow:
+			state, err := a.client.HMGet(ctx, redisKey, "start", "count").Result()
+			if err != nil {
+				return nil, err
+			}
+			if start, ok := state[0].(string); ok && start == strconv.FormatInt(now.Truncate(window).UnixMicro(), 10) {
+				usage.Count, _ = strconv.ParseInt(state[1].(string), 10, 64)
+			}
+		case AlgorithmTokenBucket:
+			tokens, err := a.client.HGet(ctx, redisKey, "tokens").Result()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			usage.Tokens, _ = strconv.ParseFloat(tokens, 64)
+		case AlgorithmGCRA:
+			tat, err := a.client.Get(ctx, redisKey).Int64()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			availableAt := time.UnixMicro(tat)
+			usage.AvailableAt = &availableAt
+		default:
+			min := strconv.FormatInt(now.Add(-window).UnixMicro(), 10)
+			usage.Count, err = a.client.ZCount(ctx, redisKey, "("+min, "+inf").Result()
+			if err != nil {
+				return nil, err
+			}
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func (a *RedisAdapter) ClearBlock(ctx context.Context, keyType string, key string) (bool, error) {
+	deleted, err := a.client.Del(ctx, a.customRedisKey("block", keyType, key)).Result()
+	if err != nil {
+		a.logger.Error("Error clearing block", "error", err)
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (a *RedisAdapter) AddOffense(ctx context.Context, keyType string, key string, decay time.Duration) (int64, error) {
+	redisKey := a.customRedisKey("offenses", keyType, key)
+	pipe := a.client.TxPipeline()
+	offenses := pipe.Incr(ctx, redisKey)
+	pipe.PExpire(ctx, redisKey, decay)
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.logger.Error("Error adding offense", "error", err)
+		return 0, err
+	}
+	return offenses.Val(), nil
+}
+
+func (a *RedisAdapter) ResetKey(ctx context.Context, keyType string, key string) error {
+	redisKeys, err := a.limitRedisKeys(ctx, keyType, key)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{a.customRedisKey("block", keyType, key), a.customRedisKey("offenses", keyType, key)}
+	for redisKey := range redisKeys {
+		keys = append(keys, redisKey)
+	}
+
+	if err := a.client.Del(ctx, keys...).Err(); err != nil {
+		a.logger.Error("Error resetting key", "error", err)
+		return err
+	}
+	return nil
+}
+
+// limitRedisKeys finds the limit state keys of every window tracked for the
+// key, mapped to the algorithm and window encoded in their names.
+func (a *RedisAdapter) limitRedisKeys(ctx context.Context, keyType string, key string) (map[string]Usage, error) {
+	suffix := a.customRedisKey("", keyType, key)
+	redisKeys := map[string]Usage{}
+	for prefix, algorithm := range limitRedisKeyPrefixes {
+		matches, err := a.scanKeys(ctx, prefix+"_*"+escapeRedisPattern(suffix))
+		if err != nil {
+			a.logger.Error("Error scanning limit keys", "error", err)
+			return nil, err
+		}
+		for _, redisKey := range matches {
+			// the pattern also matches keys whose own key ends with the suffix
+			window, found := strings.CutSuffix(strings.TrimPrefix(redisKey, prefix+"_"), suffix)
+			if !found {
+				continue
+			}
+			windowMilliseconds, err := strconv.ParseInt(window, 10, 64)
+			if err != nil {
+				continue
+			}
+			redisKeys[redisKey] = Usage{Algorithm: algorithm, WindowMilliseconds: windowMilliseconds}
+		}
+	}
+	return redisKeys, nil
+}
+
+// scanKeys returns the keys matching pattern. SCAN only walks the keys of the
+// node it runs on, so on a cluster every master is scanned.
+func (a *RedisAdapter) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := a.client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, a.client, pattern)
+	}
+
+	var mutex sync.Mutex
+	keys := []string{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		nodeKeys, err := scanNode(ctx, client, pattern)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		keys = append(keys, nodeKeys...)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func scanNode(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	keys := []string{}
+	iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func escapeRedisPattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(value)
+}
+
+const tokenPoliciesRedisKey = "token_policies"
+
+func (a *RedisAdapter) ListTokenPolicies(ctx context.Context) (map[string][]byte, error) {
+	values, err := a.client.HGetAll(ctx, tokenPoliciesRedisKey).Result()
+	if err != nil {
+		a.logger.Error("Error listing token policies", "error", err)
+		return nil, err
+	}
+
+	policies := make(map[string][]byte, len(values))
+	for token, policy := range values {
+		policies[token] = []byte(policy)
+	}
+	return policies, nil
+}
+
+func (a *RedisAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	err := a.client.HSet(ctx, tokenPoliciesRedisKey, token, policy).Err()
+	if err != nil {
+		a.logger.Error("Error setting token policy", "error", err)
+	}
+	return err
+}
+
+func (a *RedisAdapter) DeleteTokenPolicy(ctx context.Context, token string) (bool, error) {
+	deleted, err := a.client.HDel(ctx, tokenPoliciesRedisKey, token).Result()
+	if err != nil {
+		a.logger.Error("Error deleting token policy", "error", err)
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (s *RedisAdapter) limitRedisKey(prefix string, keyType string, key string, limit AccessLimit) string {
+	return s.customRedisKey(fmt.Sprintf("%s_%d", prefix, limit.window().Milliseconds()), keyType, key)
+}
+
+// customRedisKey wraps the key type and key in a hash tag, so every entry of a
+// key lands in the same Redis Cluster slot and can be used by the same script.
 func (s *RedisAdapter) customRedisKey(prefix string, keyType string, key string) string {
 	return fmt.Sprintf(
-		"%s-%s-%s",
+		"%s-{%s-%s}",
 		strings.ToLower(prefix),
 		strings.ToLower(strings.ReplaceAll(keyType, "-", "_")),
 		key,
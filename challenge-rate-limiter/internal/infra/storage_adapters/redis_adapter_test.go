@@ -0,0 +1,274 @@
+package storage_adapters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisAdapter(t *testing.T) *RedisAdapter {
+	server := miniredis.RunT(t)
+	return NewRedisAdapter(redis.NewClient(&redis.Options{Addr: server.Addr()}), nil, nil)
+}
+
+func TestGivenConcurrentCallers_WhenCheckAccess_ThenShouldNotExceedMaxAccesses(t *testing.T) {
+	algorithms := []Algorithm{AlgorithmSlidingLog, AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmGCRA}
+	for _, algorithm := range algorithms {
+		algorithm := algorithm
+		t.Run(string(algorithm), func(t *testing.T) {
+			adapter := newTestRedisAdapter(t)
+			limit := AccessLimit{Algorithm: algorithm, MaxAccesses: 10, Window: time.Hour}
+
+			var admitted atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					success, _, _, err := adapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 0)
+					assert.Nil(t, err)
+					if success {
+						admitted.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, limit.MaxAccesses, admitted.Load())
+		})
+	}
+}
+
+func TestGivenAnExceededLimit_WhenCheckAccess_ThenShouldBlockTheKeyInTheSameCall(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 2}
+
+	for i := int64(1); i <= 2; i++ {
+		success, count, block, err := adapter.CheckAccess(ctx, "TOKEN", "ABC", limit, 5000)
+		assert.Nil(t, err)
+		assert.True(t, success)
+		assert.Equal(t, i, count)
+		assert.Nil(t, block)
+	}
+
+	success, _, block, err := adapter.CheckAccess(ctx, "TOKEN", "ABC", limit, 5000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.NotNil(t, block)
+	assert.WithinDuration(t, time.Now().Add(5*time.Second), *block, time.Second)
+
+	stored, err := adapter.GetBlock(ctx, "TOKEN", "ABC")
+	assert.Nil(t, err)
+	assert.Equal(t, block.UnixNano(), stored.UnixNano())
+
+	success, _, blockedUntil, err := adapter.CheckAccess(ctx, "TOKEN", "ABC", limit, 5000)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Equal(t, block.UnixNano(), blockedUntil.UnixNano())
+}
+
+func TestGivenABlockTimeOfZero_WhenTheLimitIsExceeded_ThenShouldDenyWithoutBlocking(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{Algorithm: AlgorithmTokenBucket, MaxAccesses: 1}
+
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.2", limit, 0)
+	assert.Nil(t, err)
+	assert.True(t, success)
+
+	success, _, block, err := adapter.CheckAccess(ctx, "IP", "10.0.0.2", limit, 0)
+	assert.Nil(t, err)
+	assert.False(t, success)
+	assert.Nil(t, block)
+
+	stored, err := adapter.GetBlock(ctx, "IP", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestGivenAFractionalTokenBalance_WhenCheckAccess_ThenShouldStoreItInFixedPointNotation(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{Algorithm: AlgorithmTokenBucket, MaxAccesses: 1, Window: time.Second}
+
+	success, _, _, err := adapter.CheckAccess(ctx, "IP", "10.0.0.3", limit, 0)
+	assert.Nil(t, err)
+	assert.True(t, success)
+
+	keys, err := adapter.client.Keys(ctx, "*").Result()
+	assert.Nil(t, err)
+	stateKey := ""
+	for _, key := range keys {
+		if exists, _ := adapter.client.HExists(ctx, key, "tokens").Result(); exists {
+			stateKey = key
+		}
+	}
+	// a balance that Lua's tostring writes as "1e-06", which tonumber can't
+	// read back, with no refill until the updated_at in the future
+	assert.Nil(t, adapter.client.HSet(ctx, stateKey, "tokens", "0.000001", "updated_at", "9999999999999999").Err())
+
+	for i := 0; i < 2; i++ {
+		success, _, _, err = adapter.CheckAccess(ctx, "IP", "10.0.0.3", limit, 0)
+		assert.Nil(t, err)
+		assert.False(t, success)
+	}
+
+	tokens, err := adapter.client.HGet(ctx, stateKey, "tokens").Float64()
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.000001, tokens, 1e-9)
+}
+
+func TestGivenABlockedKey_WhenListBlocks_ThenShouldReturnTheBlock(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+
+	blockedUntil, err := adapter.AddBlock(ctx, "IP", "10.0.0.1", 60000)
+	assert.Nil(t, err)
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, "IP", blocks[0].KeyType)
+	assert.Equal(t, "10.0.0.1", blocks[0].Key)
+	assert.True(t, blockedUntil.Equal(blocks[0].BlockedUntil))
+
+	cleared, err := adapter.ClearBlock(ctx, blocks[0].KeyType, blocks[0].Key)
+	assert.Nil(t, err)
+	assert.True(t, cleared)
+
+	blocks, err = adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, blocks)
+}
+
+func TestGivenAccessesOnSeveralWindows_WhenGetUsage_ThenShouldReturnOnlyTheKeyUsage(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+	perMinute := AccessLimit{MaxAccesses: 10, Window: time.Minute}
+	perHour := AccessLimit{Algorithm: AlgorithmFixedWindow, MaxAccesses: 100, Window: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		adapter.AddAccess(ctx, "IP", "1.1", perMinute)
+		adapter.AddAccess(ctx, "IP", "1.1", perHour)
+	}
+	// a key ending with the same suffix must not be mixed up
+	adapter.AddAccess(ctx, "IP", "10.0.0.1-ip-1.1", perMinute)
+
+	usage, err := adapter.GetUsage(ctx, "IP", "1.1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Usage{
+		{Algorithm: AlgorithmSlidingLog, WindowMilliseconds: 60000, Count: 3},
+		{Algorithm: AlgorithmFixedWindow, WindowMilliseconds: 3600000, Count: 3},
+	}, usage)
+
+	assert.Nil(t, adapter.ResetKey(ctx, "IP", "1.1"))
+	usage, err = adapter.GetUsage(ctx, "IP", "1.1")
+	assert.Nil(t, err)
+	assert.Empty(t, usage)
+
+	usage, err = adapter.GetUsage(ctx, "IP", "10.0.0.1-ip-1.1")
+	assert.Nil(t, err)
+	assert.Len(t, usage, 1)
+}
+
+func TestGivenASlidingLog_WhenAChargeIsForcedPastTheLimit_ThenShouldStoreAtMostMaxAccessesEntries(t *testing.T) {
+	adapter := newTestRedisAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 3, Window: time.Minute}
+
+	count, err := adapter.ChargeAccess(ctx, "IP", "10.0.0.1", AccessLimit{MaxAccesses: 3, Window: time.Minute, Cost: 1000})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), count)
+
+	cardinality, err := adapter.client.ZCard(ctx, adapter.stateRedisKey("IP", "10.0.0.1", limit)).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), cardinality)
+
+	success, _, err := adapter.AddAccess(ctx, "IP", "10.0.0.1", limit)
+	assert.Nil(t, err)
+	assert.False(t, success)
+}
+
+func TestGivenAKey_WhenBuildingTheRedisKeys_ThenShouldShareTheSameHashTag(t *testing.T) {
+	adapter := &RedisAdapter{}
+	limit := AccessLimit{Window: time.Minute}
+
+	assert.Equal(t, "block-{ip_login-10.0.0.1}", adapter.customRedisKey("block", "IP-LOGIN", "10.0.0.1"))
+	assert.Equal(t, "access_60000-{ip_login-10.0.0.1}", adapter.limitRedisKey("access", "IP-LOGIN", "10.0.0.1", limit))
+}
+
+func TestGivenARedisConfig_WhenInitRedisAdapterWithConfig_ThenShouldAuthenticateAndSelectTheDB(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("limiter", "secret")
+
+	_, err := InitRedisAdapterWithConfig(RedisConfig{Addresses: []string{server.Addr()}})
+	assert.NotNil(t, err)
+
+	adapter, err := InitRedisAdapterWithConfig(RedisConfig{
+		Addresses: []string{server.Addr()},
+		Username:  "limiter",
+		Password:  "secret",
+		DB:        2,
+	})
+	assert.Nil(t, err)
+
+	_, err = adapter.AddBlock(context.Background(), "IP", "10.0.0.1", 60000)
+	assert.Nil(t, err)
+	assert.True(t, server.DB(2).Exists("block-{ip-10.0.0.1}"))
+}
+
+// newTestRedisClusterAdapter spreads the hash slots over two servers, as a
+// cluster of two masters.
+func newTestRedisClusterAdapter(t *testing.T) (*RedisAdapter, []*miniredis.Miniredis) {
+	servers := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: servers[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: servers[1].Addr()}}},
+			}, nil
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisAdapter(client, nil, nil), servers
+}
+
+func TestGivenAClusterClient_WhenListBlocksAndGetUsage_ThenShouldScanEveryMaster(t *testing.T) {
+	adapter, servers := newTestRedisClusterAdapter(t)
+	ctx := context.Background()
+	limit := AccessLimit{MaxAccesses: 1, Window: time.Minute}
+
+	keys := []string{}
+	for i := 1; i <= 20; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		keys = append(keys, key)
+		adapter.CheckAccess(ctx, "IP", key, limit, 60000)
+		adapter.CheckAccess(ctx, "IP", key, limit, 60000)
+	}
+	for _, server := range servers {
+		assert.NotEmpty(t, server.Keys())
+	}
+
+	blocks, err := adapter.ListBlocks(ctx)
+	assert.Nil(t, err)
+	blockedKeys := []string{}
+	for _, block := range blocks {
+		blockedKeys = append(blockedKeys, block.Key)
+	}
+	assert.ElementsMatch(t, keys, blockedKeys)
+
+	for _, key := range keys {
+		usage, err := adapter.GetUsage(ctx, "IP", key)
+		assert.Nil(t, err)
+		assert.Len(t, usage, 1, key)
+	}
+}
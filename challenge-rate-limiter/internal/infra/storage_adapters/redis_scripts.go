@@ -0,0 +1,192 @@
+package storage_adapters
+
+import "github.com/redis/go-redis/v9"
+
+// Every access script receives the block key as KEYS[1] and the limit state key
+// as KEYS[2]. ARGV[1] is the block duration in milliseconds (negative to skip
+// the block handling), ARGV[2] the block value (see blockValue) and ARGV[3]
+// the current time in unix microseconds. ARGV[4] is the cost of the
+// access and ARGV[5] is 1 to take it even past the limit. The algorithm
+// arguments start at ARGV[6]. Scripts reply {allowed, count, blockedUntil}.
+const accessScriptPrelude = `
+local blockMilliseconds = tonumber(ARGV[1])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local force = ARGV[5] == '1'
+if blockMilliseconds >= 0 then
+	local blockedUntil = redis.call('GET', KEYS[1])
+	if blockedUntil then
+		return {0, 0, blockedUntil}
+	end
+end
+local allowed = 0
+local count = 0
+`
+
+const accessScriptEpilogue = `
+if allowed == 0 and blockMilliseconds > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', blockMilliseconds)
+	return {0, count, ARGV[2]}
+end
+return {allowed, count, false}
+`
+
+func accessScript(body string) *redis.Script {
+	return redis.NewScript(accessScriptPrelude + body + accessScriptEpilogue)
+}
+
+var slidingLogScript = accessScript(`
+local max = tonumber(ARGV[6])
+local window = tonumber(ARGV[7])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '0', string.format('%d', now - window))
+count = redis.call('ZCARD', KEYS[2])
+if force or count + cost <= max then
+	-- a forced charge keeps only the latest max entries
+	for i = 1, math.min(cost, max) do
+		redis.call('ZADD', KEYS[2], ARGV[3], ARGV[3] .. '-' .. (count + i - 1))
+	end
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -max - 1)
+	count = count + cost
+	redis.call('PEXPIRE', KEYS[2], math.ceil(window / 1000))
+	allowed = 1
+end
+`)
+
+var fixedWindowScript = accessScript(`
+local max = tonumber(ARGV[7])
+if redis.call('HGET', KEYS[2], 'start') ~= ARGV[6] then
+	redis.call('HSET', KEYS[2], 'start', ARGV[6], 'count', 0)
+end
+count = tonumber(redis.call('HGET', KEYS[2], 'count'))
+if force or count + cost <= max then
+	count = redis.call('HINCRBY', KEYS[2], 'count', cost)
+	redis.call('PEXPIRE', KEYS[2], ARGV[8])
+	allowed = 1
+end
+`)
+
+var tokenBucketScript = accessScript(`
+local capacity = tonumber(ARGV[6])
+local interval = tonumber(ARGV[7])
+local state = redis.call('HMGET', KEYS[2], 'tokens', 'updated_at')
+local tokens = tonumber(state[1])
+local updatedAt = tonumber(state[2])
+if tokens == nil or updatedAt == nil then
+	tokens = capacity
+	updatedAt = now
+end
+if now > updatedAt then
+	tokens = math.min(capacity, tokens + (now - updatedAt) / interval)
+	updatedAt = now
+end
+if force or tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call('HSET', KEYS[2], 'tokens', string.format('%.9f', tokens), 'updated_at', string.format('%d', updatedAt))
+redis.call('PEXPIRE', KEYS[2], math.ceil(interval * (capacity - tokens) / 1000) + 1)
+count = capacity - math.floor(tokens)
+`)
+
+var gcraScript = accessScript(`
+local interval = tonumber(ARGV[6])
+local burst = tonumber(ARGV[7])
+local tat = tonumber(redis.call('GET', KEYS[2]) or ARGV[3])
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval * cost
+if not force and now < newTat - interval * burst then
+	count = math.ceil((tat - now) / interval)
+else
+	redis.call('SET', KEYS[2], string.format('%d', newTat), 'PX', math.ceil((newTat - now) / 1000) + 1)
+	count = math.ceil((newTat - now) / interval)
+	allowed = 1
+end
+`)
+
+// refundScript gives back ARGV[2] accesses to the state KEYS[1] of the
+// algorithm ARGV[1]. ARGV[3] is the current time in unix microseconds and
+// ARGV[4] the start of the current fixed window, the capacity of the token
+// bucket or the GCRA emission interval.
+var refundScript = redis.NewScript(`
+local algorithm = ARGV[1]
+local cost = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+if algorithm == 'sliding_log' then
+	redis.call('ZPOPMAX', KEYS[1], cost)
+elseif algorithm == 'fixed_window' then
+	if redis.call('HGET', KEYS[1], 'start') == ARGV[4] then
+		local count = tonumber(redis.call('HGET', KEYS[1], 'count'))
+		redis.call('HSET', KEYS[1], 'count', math.max(count - cost, 0))
+	end
+elseif algorithm == 'token_bucket' then
+	local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+	if tokens ~= nil then
+		tokens = math.min(tonumber(ARGV[4]), tokens + cost)
+		redis.call('HSET', KEYS[1], 'tokens', string.format('%.9f', tokens))
+	end
+else
+	local tat = tonumber(redis.call('GET', KEYS[1]))
+	if tat ~= nil then
+		tat = math.max(tat - tonumber(ARGV[4]) * cost, now)
+		redis.call('SET', KEYS[1], string.format('%d', tat), 'PX', math.ceil((tat - now) / 1000) + 1)
+	end
+end
+return 0
+`)
+
+// syncScript adds the accesses counted by a replica (ARGV[2]) to the fixed
+// window hash KEYS[2] for the window started at ARGV[1], in unix microseconds,
+// and replies {count, blockedUntil} with the global count and the block of
+// KEYS[1]. Deltas of a window older than the stored one are dropped.
+var syncScript = redis.NewScript(`
+local windowStart = tonumber(ARGV[1])
+local start = tonumber(redis.call('HGET', KEYS[2], 'start'))
+if start == nil or start < windowStart then
+	redis.call('HSET', KEYS[2], 'start', ARGV[1], 'count', 0)
+	start = windowStart
+end
+local count = 0
+if start == windowStart then
+	count = redis.call('HINCRBY', KEYS[2], 'count', ARGV[2])
+	redis.call('PEXPIRE', KEYS[2], ARGV[3])
+end
+return {count, redis.call('GET', KEYS[1])}
+`)
+
+// The slot scripts keep the leases of a key in the sorted set KEYS[1], scored
+// by their expiration in unix microseconds. ARGV[1] is the current time, so
+// the expired leases are dropped first, ARGV[2] the expiration of the lease
+// ARGV[3] and ARGV[4] its ttl in milliseconds. The set lives as long as its
+// latest lease.
+const slotScriptPrelude = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local function extendExpiration()
+	if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[4]) then
+		redis.call('PEXPIRE', KEYS[1], ARGV[4])
+	end
+end
+`
+
+// acquireSlotScript adds the lease when there are less than ARGV[5] leases and
+// replies {acquired, count}.
+var acquireSlotScript = redis.NewScript(slotScriptPrelude + `
+local count = redis.call('ZCARD', KEYS[1])
+if count >= tonumber(ARGV[5]) then
+	return {0, count}
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+extendExpiration()
+return {1, count + 1}
+`)
+
+// renewSlotScript replies 1 when the lease was still held and is extended.
+var renewSlotScript = redis.NewScript(slotScriptPrelude + `
+if not redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+extendExpiration()
+return 1
+`)
@@ -5,8 +5,73 @@ import (
 	"time"
 )
 
+type Algorithm string
+
+const (
+	AlgorithmSlidingLog  Algorithm = "sliding_log"
+	AlgorithmFixedWindow Algorithm = "fixed_window"
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	AlgorithmGCRA        Algorithm = "gcra"
+)
+
+// AccessLimit describes how many accesses are admitted per Window and which
+// algorithm enforces it. Window defaults to one second. Burst is the bucket
+// capacity for the token bucket and GCRA algorithms; when zero it defaults to
+// MaxAccesses. Cost is the number of accesses taken at once, one when zero; an
+// access costing more than the quota is never admitted.
+type AccessLimit struct {
+	Algorithm   Algorithm
+	MaxAccesses int64
+	Window      time.Duration
+	Burst       int64
+	Cost        int64
+}
+
+type Block struct {
+	KeyType      string    `json:"keyType"`
+	Key          string    `json:"key"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+}
+
+// Usage is a snapshot of the state kept for one limit of a key. Count holds the
+// accesses of the current window for the sliding log and fixed window
+// algorithms, Tokens the tokens left in the token bucket and AvailableAt the
+// theoretical arrival time tracked by GCRA.
+type Usage struct {
+	Algorithm          Algorithm  `json:"algorithm"`
+	WindowMilliseconds int64      `json:"windowMilliseconds"`
+	Count              int64      `json:"count"`
+	Tokens             float64    `json:"tokens,omitempty"`
+	AvailableAt        *time.Time `json:"availableAt,omitempty"`
+}
+
 type StorageAdapter interface {
-	AddAccess(ctx context.Context, keyType string, key string, maxAccesses int64) (bool, int64, error)
+	AddAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (bool, int64, error)
+	// CheckAccess checks the current block, admits the access and blocks the
+	// key for blockMilliseconds when the limit is exceeded, as one atomic step.
+	// The returned time is set whenever the key is blocked, with a zero count
+	// when the block already existed.
+	CheckAccess(ctx context.Context, keyType string, key string, limit AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error)
+	// ChargeAccess records limit.Cost more accesses even past the limit, so the
+	// following ones wait for them to expire. It is used when the real cost of
+	// an access is only known after it was admitted.
+	ChargeAccess(ctx context.Context, keyType string, key string, limit AccessLimit) (int64, error)
+	// RefundAccess gives back the limit.Cost accesses admitted by CheckAccess
+	// when another limit of the same request denied it.
+	RefundAccess(ctx context.Context, keyType string, key string, limit AccessLimit) error
 	GetBlock(ctx context.Context, keyType string, key string) (*time.Time, error)
 	AddBlock(ctx context.Context, keyType string, key string, milliseconds int64) (*time.Time, error)
+	ListBlocks(ctx context.Context) ([]Block, error)
+	GetUsage(ctx context.Context, keyType string, key string) ([]Usage, error)
+	ClearBlock(ctx context.Context, keyType string, key string) (bool, error)
+	// AddOffense counts one more block of the key and returns how many blocks
+	// it got since it last went decay without one.
+	AddOffense(ctx context.Context, keyType string, key string, decay time.Duration) (int64, error)
+	// ResetKey removes the block, the offenses and every access recorded for
+	// the key.
+	ResetKey(ctx context.Context, keyType string, key string) error
+	// Token policies are stored as opaque documents indexed by token.
+	ListTokenPolicies(ctx context.Context) (map[string][]byte, error)
+	SetTokenPolicy(ctx context.Context, token string, policy []byte) error
+	DeleteTokenPolicy(ctx context.Context, token string) (bool, error)
 }
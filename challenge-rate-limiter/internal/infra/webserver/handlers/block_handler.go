@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+type BlockHandler struct {
+	StorageAdapter storage_adapters.StorageAdapter
+}
+
+type keyUsageResponse struct {
+	KeyType      string                   `json:"keyType"`
+	Key          string                   `json:"key"`
+	BlockedUntil *time.Time               `json:"blockedUntil,omitempty"`
+	Usage        []storage_adapters.Usage `json:"usage"`
+}
+
+func NewBlockHandler(storageAdapter storage_adapters.StorageAdapter) *BlockHandler {
+	return &BlockHandler{
+		StorageAdapter: storageAdapter,
+	}
+}
+
+func (h *BlockHandler) List(w http.ResponseWriter, r *http.Request) {
+	blocks, err := h.StorageAdapter.ListBlocks(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, blocks)
+}
+
+func (h *BlockHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	keyType, key, ok := keyQuery(w, r)
+	if !ok {
+		return
+	}
+
+	cleared, err := h.StorageAdapter.ClearBlock(r.Context(), keyType, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cleared {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BlockHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	keyType, key, ok := keyQuery(w, r)
+	if !ok {
+		return
+	}
+
+	blockedUntil, err := h.StorageAdapter.GetBlock(r.Context(), keyType, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	usage, err := h.StorageAdapter.GetUsage(r.Context(), keyType, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keyUsageResponse{
+		KeyType:      keyType,
+		Key:          key,
+		BlockedUntil: blockedUntil,
+		Usage:        usage,
+	})
+}
+
+func (h *BlockHandler) ResetKey(w http.ResponseWriter, r *http.Request) {
+	keyType, key, ok := keyQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.StorageAdapter.ResetKey(r.Context(), keyType, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyQuery reads the key from the query string, since keys such as route
+// patterns may contain slashes.
+func keyQuery(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	keyType, key := r.URL.Query().Get("keyType"), r.URL.Query().Get("key")
+	if keyType == "" || key == "" {
+		http.Error(w, "keyType and key are required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return keyType, key, true
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBlockTestHandler(t *testing.T) (*BlockHandler, *storage_adapters.MemoryAdapter) {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	t.Cleanup(func() { storageAdapter.Close() })
+
+	return NewBlockHandler(storageAdapter), storageAdapter
+}
+
+func sendBlockTestRequest(handler http.HandlerFunc, method string, keyType string, key string) *httptest.ResponseRecorder {
+	query := url.Values{}
+	if keyType != "" {
+		query.Set("keyType", keyType)
+	}
+	if key != "" {
+		query.Set("key", key)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(method, "/admin?"+query.Encode(), nil))
+	return recorder
+}
+
+func TestGivenBlockedKeys_WhenListingBlocks_ThenShouldReturnThem(t *testing.T) {
+	handler, storageAdapter := newBlockTestHandler(t)
+	blockedUntil, err := storageAdapter.AddBlock(context.Background(), "IP:GET /users/{id}", "10.0.0.1", 60000)
+	assert.Nil(t, err)
+
+	recorder := sendBlockTestRequest(handler.List, "GET", "", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var blocks []storage_adapters.Block
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&blocks))
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, "IP:GET /users/{id}", blocks[0].KeyType)
+	assert.Equal(t, "10.0.0.1", blocks[0].Key)
+	assert.True(t, blockedUntil.Equal(blocks[0].BlockedUntil))
+}
+
+func TestGivenABlockedKey_WhenClearingItsBlock_ThenShouldRemoveItOnce(t *testing.T) {
+	handler, storageAdapter := newBlockTestHandler(t)
+	storageAdapter.AddBlock(context.Background(), "TOKEN", "ABC", 60000)
+
+	recorder := sendBlockTestRequest(handler.Clear, "DELETE", "TOKEN", "ABC")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	block, err := storageAdapter.GetBlock(context.Background(), "TOKEN", "ABC")
+	assert.Nil(t, err)
+	assert.Nil(t, block)
+
+	recorder = sendBlockTestRequest(handler.Clear, "DELETE", "TOKEN", "ABC")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestGivenAKeyWithAccesses_WhenGettingIt_ThenShouldReturnItsBlockAndUsage(t *testing.T) {
+	handler, storageAdapter := newBlockTestHandler(t)
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+	storageAdapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 60000)
+	storageAdapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 60000)
+
+	recorder := sendBlockTestRequest(handler.GetKey, "GET", "IP", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var response keyUsageResponse
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, "IP", response.KeyType)
+	assert.Equal(t, "10.0.0.1", response.Key)
+	assert.NotNil(t, response.BlockedUntil)
+	assert.Len(t, response.Usage, 1)
+	assert.Equal(t, int64(1), response.Usage[0].Count)
+	assert.Equal(t, int64(60000), response.Usage[0].WindowMilliseconds)
+
+	recorder = sendBlockTestRequest(handler.GetKey, "GET", "IP", "10.0.0.2")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	response = keyUsageResponse{}
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Nil(t, response.BlockedUntil)
+	assert.Empty(t, response.Usage)
+}
+
+func TestGivenABlockedKey_WhenResettingIt_ThenShouldForgetItsBlockAndUsage(t *testing.T) {
+	handler, storageAdapter := newBlockTestHandler(t)
+	limit := storage_adapters.AccessLimit{MaxAccesses: 1, Window: time.Minute}
+	storageAdapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 60000)
+	storageAdapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 60000)
+
+	recorder := sendBlockTestRequest(handler.ResetKey, "DELETE", "IP", "10.0.0.1")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	success, _, _, err := storageAdapter.CheckAccess(context.Background(), "IP", "10.0.0.1", limit, 60000)
+	assert.Nil(t, err)
+	assert.True(t, success)
+}
+
+func TestGivenAMissingKeyParameter_WhenCallingAKeyEndpoint_ThenShouldReturnBadRequest(t *testing.T) {
+	handler, _ := newBlockTestHandler(t)
+
+	for _, endpoint := range []http.HandlerFunc{handler.Clear, handler.GetKey, handler.ResetKey} {
+		assert.Equal(t, http.StatusBadRequest, sendBlockTestRequest(endpoint, "GET", "IP", "").Code)
+		assert.Equal(t, http.StatusBadRequest, sendBlockTestRequest(endpoint, "GET", "", "10.0.0.1").Code)
+	}
+}
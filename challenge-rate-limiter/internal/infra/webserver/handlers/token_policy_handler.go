@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+)
+
+type TokenPolicyHandler struct {
+	TokenRegistry *middlewares.TokenRegistry
+}
+
+func NewTokenPolicyHandler(tokenRegistry *middlewares.TokenRegistry) *TokenPolicyHandler {
+	return &TokenPolicyHandler{
+		TokenRegistry: tokenRegistry,
+	}
+}
+
+func (h *TokenPolicyHandler) List(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.TokenRegistry.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func (h *TokenPolicyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.TokenRegistry.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokenConfig, ok := tokens[chi.URLParam(r, "token")]
+	if !ok {
+		http.Error(w, middlewares.ErrTokenPolicyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenConfig)
+}
+
+func (h *TokenPolicyHandler) Put(w http.ResponseWriter, r *http.Request) {
+	var tokenConfig middlewares.RateLimiterRateConfig
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tokenConfig); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.TokenRegistry.Set(r.Context(), chi.URLParam(r, "token"), &tokenConfig)
+	if errors.Is(err, middlewares.ErrInvalidTokenPolicy) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenConfig)
+}
+
+func (h *TokenPolicyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := h.TokenRegistry.Delete(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, middlewares.ErrTokenPolicyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingTokenPolicyStorageAdapter fails to store the token policies.
+type failingTokenPolicyStorageAdapter struct {
+	storage_adapters.StorageAdapter
+}
+
+func (a failingTokenPolicyStorageAdapter) SetTokenPolicy(ctx context.Context, token string, policy []byte) error {
+	return errors.New("storage unavailable")
+}
+
+func newTokenPolicyTestRouter(storageAdapter storage_adapters.StorageAdapter) http.Handler {
+	handler := NewTokenPolicyHandler(middlewares.NewTokenRegistry(storageAdapter, nil, nil))
+	router := chi.NewRouter()
+	router.Put("/admin/tokens/{token}", handler.Put)
+	return router
+}
+
+func sendTokenPolicyTestRequest(router http.Handler, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("PUT", "/admin/tokens/GOLD", strings.NewReader(body)))
+	return recorder
+}
+
+func TestGivenATokenPolicy_WhenPuttingIt_ThenShouldRespondBadRequestOnlyForInvalidLimits(t *testing.T) {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	t.Cleanup(func() { storageAdapter.Close() })
+	router := newTokenPolicyTestRouter(storageAdapter)
+
+	assert.Equal(t, http.StatusOK, sendTokenPolicyTestRequest(router, `{"maxRequestsPerSecond":100}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendTokenPolicyTestRequest(router, `{"algorithm":"leaky"}`).Code)
+
+	router = newTokenPolicyTestRouter(failingTokenPolicyStorageAdapter{StorageAdapter: storageAdapter})
+	assert.Equal(t, http.StatusInternalServerError, sendTokenPolicyTestRequest(router, `{"maxRequestsPerSecond":100}`).Code)
+}
@@ -0,0 +1,212 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AccessList lists client IPs, CIDR ranges (e.g. "10.0.0.0/8" or
+// "2001:db8::/32") and tokens. Requests matching the DenyList of the
+// RateLimiterConfig are rejected with 403 and the ones matching the AllowList
+// skip the limits, the DenyList winning when both match.
+type AccessList struct {
+	IPs    []string `json:"ips,omitempty"`
+	Tokens []string `json:"tokens,omitempty"`
+}
+
+func (l AccessList) validate() error {
+	for _, entry := range l.IPs {
+		if _, err := parsePrefix(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l AccessList) merge(other AccessList) AccessList {
+	return AccessList{
+		IPs:    append(append([]string{}, l.IPs...), other.IPs...),
+		Tokens: append(append([]string{}, l.Tokens...), other.Tokens...),
+	}
+}
+
+// ParseAccessList reads the comma separated IPs and CIDR ranges of ips and
+// the comma separated tokens.
+func ParseAccessList(ips string, tokens string) (AccessList, error) {
+	list := AccessList{IPs: splitList(ips), Tokens: splitList(tokens)}
+	return list, list.validate()
+}
+
+func splitList(value string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// accessMatcher is the compiled AccessList. The ranges are kept in a binary
+// trie per address family, so a lookup walks at most 32 or 128 nodes however
+// long the list is.
+type accessMatcher struct {
+	ipv4   *prefixNode
+	ipv6   *prefixNode
+	tokens map[string]struct{}
+}
+
+type prefixNode struct {
+	children [2]*prefixNode
+	terminal bool
+}
+
+// newAccessMatcher compiles the list, returning nil when it's empty. Invalid
+// entries are skipped, the lists are validated when they are loaded.
+func newAccessMatcher(list AccessList) *accessMatcher {
+	if len(list.IPs) == 0 && len(list.Tokens) == 0 {
+		return nil
+	}
+
+	matcher := &accessMatcher{tokens: map[string]struct{}{}}
+	for _, token := range list.Tokens {
+		matcher.tokens[token] = struct{}{}
+	}
+	for _, entry := range list.IPs {
+		if prefix, err := parsePrefix(entry); err == nil {
+			matcher.insert(prefix)
+		}
+	}
+	return matcher
+}
+
+func (m *accessMatcher) root(addr netip.Addr, create bool) *prefixNode {
+	root := &m.ipv6
+	if addr.Is4() {
+		root = &m.ipv4
+	}
+	if *root == nil && create {
+		*root = &prefixNode{}
+	}
+	return *root
+}
+
+func (m *accessMatcher) insert(prefix netip.Prefix) {
+	node := m.root(prefix.Addr(), true)
+	bytes := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits() && !node.terminal; i++ {
+		bit := bytes[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	// a shorter range already covers the longer ones
+	node.terminal = true
+	node.children = [2]*prefixNode{}
+}
+
+func (m *accessMatcher) containsIP(ip string) bool {
+	if m == nil || (m.ipv4 == nil && m.ipv6 == nil) {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	node := m.root(addr, false)
+	bytes := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(bytes)*8 {
+			return false
+		}
+		node = node.children[bytes[i/8]>>(7-i%8)&1]
+	}
+	return false
+}
+
+func (m *accessMatcher) containsToken(token string) bool {
+	if m == nil || token == "" {
+		return false
+	}
+	_, ok := m.tokens[token]
+	return ok
+}
+
+func (m *accessMatcher) hasIPs() bool {
+	return m != nil && (m.ipv4 != nil || m.ipv6 != nil)
+}
+
+// listed checks the token and the client IP against the deny list, then the
+// allow list, returning the decision and the key that matched. The decision is
+// empty when neither list has the request. clientIP is only called when the
+// lists have IPs.
+func (c *RateLimiterConfig) listed(token string, clientIP func() string) (string, string, string) {
+	if c.denied == nil && c.allowed == nil {
+		return "", "", ""
+	}
+
+	ip := ""
+	if c.denied.hasIPs() || c.allowed.hasIPs() {
+		ip = clientIP()
+	}
+	for _, list := range []struct {
+		matcher  *accessMatcher
+		decision string
+	}{{c.denied, decisionDenied}, {c.allowed, decisionAllowlisted}} {
+		if list.matcher.containsToken(token) {
+			return list.decision, "TOKEN", token
+		}
+		if list.matcher.containsIP(ip) {
+			return list.decision, "IP", ip
+		}
+	}
+	return "", "", ""
+}
+
+// observeListed records a request decided by the lists.
+func (c *RateLimiterConfig) observeListed(ctx context.Context, decision string, keyType string, key string) {
+	c.Metrics.observeListed(keyType, decision)
+
+	message, level := "Request allowlisted", slog.LevelDebug
+	if decision == decisionDenied {
+		message, level = "Request denied", slog.LevelInfo
+	}
+	c.Logger.LogAttrs(ctx, level, message, c.keyAttrs(keyType, key)...)
+}
+
+// clientIP is the remote address, or the forwarded client IP when the request
+// comes from one of the TrustedProxies. The IPKeyExtractor isn't used, as a
+// custom key could be spoofed by the client.
+func (c *RateLimiterConfig) clientIP(r *http.Request) string {
+	return c.clientIPExtractor.ExtractKey(r)
+}
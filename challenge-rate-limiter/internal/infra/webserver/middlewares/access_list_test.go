@@ -0,0 +1,156 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenIPsAndCIDRRanges_WhenMatchingAddresses_ThenShouldMatchTheCoveredOnes(t *testing.T) {
+	matcher := newAccessMatcher(AccessList{IPs: []string{"10.0.0.0/8", "192.168.1.15", "10.1.0.0/16", "2001:db8::/32", "::ffff:172.16.0.0/112"}})
+
+	for ip, expected := range map[string]bool{
+		"10.200.3.4":      true,
+		"11.0.0.1":        false,
+		"192.168.1.15":    true,
+		"192.168.1.16":    false,
+		"::ffff:10.0.0.1": true,
+		"172.16.5.5":      true,
+		"172.17.0.1":      false,
+		"2001:db8:1::1":   true,
+		"2001:db9::1":     false,
+		"not an ip":       false,
+		"":                false,
+	} {
+		assert.Equal(t, expected, matcher.containsIP(ip), ip)
+	}
+}
+
+func TestGivenALargeList_WhenMatchingAddresses_ThenShouldMatchEveryRange(t *testing.T) {
+	list := AccessList{}
+	for i := 0; i < 10000; i++ {
+		list.IPs = append(list.IPs, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+	matcher := newAccessMatcher(list)
+
+	assert.True(t, matcher.containsIP("10.39.15.200"))
+	assert.False(t, matcher.containsIP("10.39.16.1"))
+	assert.False(t, matcher.containsIP("11.0.0.1"))
+}
+
+func TestGivenAnInvalidEntry_WhenParsingTheAccessList_ThenShouldReceiveAnError(t *testing.T) {
+	list, err := ParseAccessList(" 10.0.0.0/8, 127.0.0.1 ", "ABC,,DEF")
+	assert.Nil(t, err)
+	assert.Equal(t, AccessList{IPs: []string{"10.0.0.0/8", "127.0.0.1"}, Tokens: []string{"ABC", "DEF"}}, list)
+
+	_, err = ParseAccessList("10.0.0.0/33", "")
+	assert.Error(t, err)
+	_, err = ParseAccessList("localhost", "")
+	assert.Error(t, err)
+}
+
+func newAccessListTestRouter(limiter *LiveRateLimiter) http.Handler {
+	router := chi.NewRouter()
+	router.Use(limiter.Middleware)
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	return router
+}
+
+func newAccessListTestLimiter(t *testing.T, config *RateLimiterConfig) *LiveRateLimiter {
+	storageAdapter := newTestStorageAdapter(t)
+
+	config.LimitByIP = &RateLimiterRateConfig{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 10000}
+	config.LimitByToken = &RateLimiterRateConfig{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 10000}
+	config.StorageAdapter = storageAdapter
+	return NewLiveRateLimiter(config)
+}
+
+func sendAccessListTestRequest(router http.Handler, ip string, token string) int {
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = ip + ":1234"
+	if token != "" {
+		request.Header.Set("API_KEY", token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestGivenTheAccessLists_WhenRequestsArrive_ThenShouldSkipTheAllowedAndForbidTheDenied(t *testing.T) {
+	router := newAccessListTestRouter(newAccessListTestLimiter(t, &RateLimiterConfig{
+		AllowList: AccessList{IPs: []string{"10.0.0.0/8"}, Tokens: []string{"MONITORING"}},
+		DenyList:  AccessList{IPs: []string{"203.0.113.0/24", "10.6.6.6"}, Tokens: []string{"LEAKED"}},
+	}))
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, sendAccessListTestRequest(router, "10.1.2.3", ""))
+		assert.Equal(t, http.StatusOK, sendAccessListTestRequest(router, "192.168.0.1", "MONITORING"))
+	}
+
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "203.0.113.7", ""))
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "192.168.0.1", "LEAKED"))
+	// the deny list wins over the allow list
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "10.6.6.6", ""))
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "10.1.2.3", "LEAKED"))
+
+	assert.Equal(t, http.StatusOK, sendAccessListTestRequest(router, "192.168.0.1", ""))
+	assert.Equal(t, http.StatusTooManyRequests, sendAccessListTestRequest(router, "192.168.0.1", ""))
+}
+
+func TestGivenALiveRateLimiter_WhenTheAccessListsChange_ThenShouldKeepThemAcrossPolicyReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("deny:\n  ips: [198.51.100.0/24]\n"), 0o600))
+	policyFile, err := LoadRateLimiterPolicyFile(path)
+	assert.Nil(t, err)
+
+	limiter := newAccessListTestLimiter(t, &RateLimiterConfig{})
+	limiter.ApplyPolicyFile(policyFile)
+	router := newAccessListTestRouter(limiter)
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "198.51.100.1", ""))
+
+	assert.Error(t, limiter.SetAccessLists(AccessList{IPs: []string{"10.0.0.0/64"}}, AccessList{}))
+	assert.Nil(t, limiter.SetAccessLists(AccessList{Tokens: []string{"MONITORING"}}, AccessList{IPs: []string{"192.0.2.1"}}))
+	limiter.ApplyPolicyFile(&RateLimiterPolicyFile{})
+
+	assert.Equal(t, http.StatusForbidden, sendAccessListTestRequest(router, "192.0.2.1", ""))
+	assert.Equal(t, http.StatusOK, sendAccessListTestRequest(router, "198.51.100.1", ""))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, sendAccessListTestRequest(router, "198.51.100.2", "MONITORING"))
+	}
+}
+
+func TestGivenTrustedProxies_WhenMatchingTheAccessLists_ThenShouldOnlyBelieveTheirForwardedHeaders(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+	router := newAccessListTestRouter(newAccessListTestLimiter(t, &RateLimiterConfig{
+		AllowList:      AccessList{IPs: []string{"192.0.2.1"}},
+		DenyList:       AccessList{IPs: []string{"203.0.113.0/24"}},
+		TrustedProxies: proxies,
+		// a key extractor the client controls must not choose the listed IP
+		IPKeyExtractor: NewHeaderKeyExtractor("X-Client-IP"),
+	}))
+	send := func(remoteAddr string, forwardedFor string, clientIP string) int {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		request.Header.Set("X-Client-IP", clientIP)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, send("10.0.0.5:1234", "203.0.113.7", "198.51.100.1"))
+	// an untrusted client can't claim to be an allowed IP
+	assert.Equal(t, http.StatusOK, send("198.51.100.1:1234", "192.0.2.1", "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.1:1234", "192.0.2.1", "198.51.100.1"))
+	assert.Equal(t, http.StatusOK, send("198.51.100.2:1234", "", "192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.2:1234", "", "192.0.2.1"))
+	// nor hide behind one
+	assert.Equal(t, http.StatusForbidden, send("203.0.113.7:1234", "", "198.51.100.3"))
+}
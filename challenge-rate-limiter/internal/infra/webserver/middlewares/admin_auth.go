@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// NewAdminAuth only lets through requests carrying "Authorization: Bearer <apiKey>".
+func NewAdminAuth(apiKey string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if apiKey == "" || !found || subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
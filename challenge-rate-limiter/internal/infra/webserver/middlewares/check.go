@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDenied is returned by Check and Acquire for the calls in the DenyList.
+var ErrDenied = errors.New("denied by the rate limiter deny list")
+
+// Decision is the outcome of Check.
+type Decision struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration
+	RetryAfter time.Duration
+	// BlockedUntil is set when the caller is blocked.
+	BlockedUntil *time.Time
+	// Concurrent is set when Acquire found every concurrency slot of the
+	// caller taken.
+	Concurrent bool
+}
+
+// Check rate limits a call that doesn't go through net/http, such as a gRPC
+// call. token and clientKey take the place of the TokenKeyExtractor and the
+// IPKeyExtractor keys, and pattern selects the policies without a Method whose
+// Pattern is the same, e.g. a gRPC full method name. The cost is the one of the
+// policy unless WithRequestCost sets it in ctx. A nil Decision means the
+// call isn't limited, either because it has no key, because it is in the
+// AllowList or because the storage failed open. Calls in the DenyList return
+// ErrDenied.
+func (l *LiveRateLimiter) Check(ctx context.Context, pattern string, token string, clientKey string) (*Decision, error) {
+	decision, _, err := l.check(ctx, pattern, token, clientKey, false)
+	return decision, err
+}
+
+// Acquire is Check for calls that last, also taking a concurrency slot of the
+// caller when its limit sets MaxConcurrentRequests, before the quota is
+// consumed. Without a free slot the Decision isn't Allowed and is Concurrent.
+// release frees the slot, it is never nil and must be called once the call
+// ends, whatever the Decision.
+func (l *LiveRateLimiter) Acquire(ctx context.Context, pattern string, token string, clientKey string) (*Decision, func(), error) {
+	return l.check(ctx, pattern, token, clientKey, true)
+}
+
+func (l *LiveRateLimiter) check(ctx context.Context, pattern string, token string, clientKey string, acquire bool) (*Decision, func(), error) {
+	release := func() {}
+	config := l.Config()
+	decision, listedKeyType, listedKey := config.listed(token, func() string {
+		return clientKey
+	})
+	if decision != "" {
+		config.observeListed(ctx, decision, listedKeyType, listedKey)
+		if decision == decisionDenied {
+			return nil, release, ErrDenied
+		}
+		return nil, release, nil
+	}
+
+	policy := config.policyMatching("", pattern)
+	keyType, key, rateConfig := config.limitFor(policy, token, func() string {
+		return clientKey
+	})
+
+	if acquire {
+		slotRelease, acquired, err := config.acquireSlot(ctx, keyType, key, rateConfig)
+		if err != nil {
+			return nil, release, err
+		}
+		if !acquired {
+			return &Decision{Limit: rateConfig.MaxConcurrentRequests, RetryAfter: time.Second, Concurrent: true}, release, nil
+		}
+		release = slotRelease
+	}
+
+	result, err := config.decide(ctx, keyType, key, rateConfig, requestCost(ctx, policy))
+	if err != nil || result == nil {
+		return nil, release, err
+	}
+
+	return &Decision{
+		Allowed:      result.allowed,
+		Limit:        result.limit,
+		Remaining:    result.remaining,
+		Reset:        result.reset,
+		RetryAfter:   result.retryAfter,
+		BlockedUntil: result.blockedUntil,
+	}, release, nil
+}
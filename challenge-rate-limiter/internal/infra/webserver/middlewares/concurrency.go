@@ -0,0 +1,103 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+const defaultConcurrencyLeaseTTL = 30 * time.Second
+
+func (c *RateLimiterConfig) concurrencyLeaseTTL() time.Duration {
+	if c.ConcurrencyLeaseTTL > 0 {
+		return c.ConcurrencyLeaseTTL
+	}
+	return defaultConcurrencyLeaseTTL
+}
+
+// acquireSlot takes a concurrency slot of the key when its limits set
+// MaxConcurrentRequests, applying the FailureMode when the ConcurrencyAdapter
+// fails. The slot is renewed in the background until the returned function
+// releases it. It returns false when every slot is taken.
+func (c *RateLimiterConfig) acquireSlot(ctx context.Context, keyType string, key string, rateConfig *RateLimiterRateConfig) (func(), bool, error) {
+	if key == "" || rateConfig == nil || rateConfig.MaxConcurrentRequests <= 0 || c.ConcurrencyAdapter == nil {
+		return func() {}, true, nil
+	}
+
+	adapter := c.ConcurrencyAdapter
+	lease, count, err := c.acquireLease(ctx, adapter, keyType, key, rateConfig.MaxConcurrentRequests)
+	if err != nil {
+		c.logError(ctx, keyType, key, err)
+		switch c.FailureMode {
+		case FailureModeOpen:
+			return func() {}, true, nil
+		case FailureModeLocal:
+			fallback, ok := c.FallbackStorageAdapter.(storage_adapters.ConcurrencyAdapter)
+			if !ok {
+				return func() {}, true, nil
+			}
+			adapter = fallback
+			lease, count, err = c.acquireLease(ctx, adapter, keyType, key, rateConfig.MaxConcurrentRequests)
+		}
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if lease == "" {
+		c.Metrics.observeConcurrencyLimited(keyType)
+		c.Logger.LogAttrs(ctx, slog.LevelInfo, "Request limited by concurrency",
+			append(c.keyAttrs(keyType, key), slog.Int64("limit", rateConfig.MaxConcurrentRequests), slog.Int64("in_flight", count))...)
+		return nil, false, nil
+	}
+
+	stop := make(chan struct{})
+	go c.renewSlot(adapter, keyType, key, lease, stop)
+	return func() {
+		close(stop)
+		// released even when the request was canceled
+		start := time.Now()
+		err := adapter.ReleaseSlot(context.WithoutCancel(ctx), keyType, key, lease)
+		c.Metrics.observeStorage("release_slot", start, err)
+		if err != nil {
+			c.Logger.LogAttrs(ctx, slog.LevelError, "Error releasing concurrency slot", append(c.keyAttrs(keyType, key), slog.Any("error", err))...)
+		}
+	}, true, nil
+}
+
+func (c *RateLimiterConfig) acquireLease(ctx context.Context, adapter storage_adapters.ConcurrencyAdapter, keyType string, key string, maxSlots int64) (string, int64, error) {
+	start := time.Now()
+	lease, count, err := adapter.AcquireSlot(ctx, keyType, key, maxSlots, c.concurrencyLeaseTTL())
+	c.Metrics.observeStorage("acquire_slot", start, err)
+	return lease, count, err
+}
+
+// renewSlot renews the lease every third of its ttl, so it only expires when
+// the replica stops.
+func (c *RateLimiterConfig) renewSlot(adapter storage_adapters.ConcurrencyAdapter, keyType string, key string, lease string, stop <-chan struct{}) {
+	ttl := c.concurrencyLeaseTTL()
+	ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			renewed, err := adapter.RenewSlot(context.Background(), keyType, key, lease, ttl)
+			c.Metrics.observeStorage("renew_slot", start, err)
+			if err != nil {
+				c.Logger.LogAttrs(context.Background(), slog.LevelError, "Error renewing concurrency slot", append(c.keyAttrs(keyType, key), slog.Any("error", err))...)
+				continue
+			}
+			if !renewed {
+				c.Logger.LogAttrs(context.Background(), slog.LevelWarn, "Concurrency slot expired before the request ended", c.keyAttrs(keyType, key)...)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenAConcurrencyLimit_WhenEverySlotIsTaken_ThenShouldRejectTheRequestUntilOneIsReleased(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxConcurrentRequests: 2},
+		StorageAdapter: storageAdapter,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-finish
+		}
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/slow", nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		}()
+		<-started
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+
+	// another client has its own slots
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.2:1234"
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	close(finish)
+	wg.Wait()
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestGivenAConcurrencyLimit_WhenARequestIsRejectedForConcurrency_ThenShouldNotConsumeTheQuota(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP: &RateLimiterRateConfig{
+			Windows:               []RateLimiterWindowConfig{{MaxRequests: 2, WindowMilliseconds: 60000}},
+			MaxConcurrentRequests: 1,
+		},
+		StorageAdapter: storageAdapter,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-finish
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	}
+
+	close(finish)
+	<-done
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+}
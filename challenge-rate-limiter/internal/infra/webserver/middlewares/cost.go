@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+// ResponseInfo describes the response of a request that was let through.
+type ResponseInfo struct {
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// ResponseCostFunc returns the units charged on top of the cost of a request
+// once its response is written, e.g. one unit per megabyte sent.
+type ResponseCostFunc func(r *http.Request, response ResponseInfo) int64
+
+type requestCostKey struct{}
+
+type requestChargeKey struct{}
+
+// WithRequestCost sets the cost of the request, replacing the Cost of its
+// policy. It is meant for the middlewares running before the rate limiter.
+func WithRequestCost(ctx context.Context, cost int64) context.Context {
+	return context.WithValue(ctx, requestCostKey{}, cost)
+}
+
+// ChargeRequest charges units on top of the cost of the request being handled
+// once the handler returns. Units can't be refunded, so values below one are
+// ignored, as well as calls outside the rate limiter.
+func ChargeRequest(ctx context.Context, units int64) {
+	if charge, ok := ctx.Value(requestChargeKey{}).(*atomic.Int64); ok && units > 0 {
+		charge.Add(units)
+	}
+}
+
+// requestCost is the cost set by WithRequestCost, then the one of the policy.
+func requestCost(ctx context.Context, policy *RateLimiterPolicy) int64 {
+	if cost, ok := ctx.Value(requestCostKey{}).(int64); ok && cost > 0 {
+		return cost
+	}
+	if policy != nil && policy.Cost > 0 {
+		return policy.Cost
+	}
+	return 1
+}
+
+// serveCharged runs the handler and charges the key the units reported by
+// ChargeRequest and the ResponseCost in the storageAdapter that admitted the
+// request. The charge is made even when the client has already gone away, so it
+// doesn't use the cancellation of the request context.
+func (c *RateLimiterConfig) serveCharged(w http.ResponseWriter, r *http.Request, next http.Handler, storageAdapter storage_adapters.StorageAdapter, keyType string, key string, rateConfig *RateLimiterRateConfig) {
+	charge := &atomic.Int64{}
+	r = r.WithContext(context.WithValue(r.Context(), requestChargeKey{}, charge))
+	if c.ResponseCost == nil {
+		next.ServeHTTP(w, r)
+		c.charge(context.WithoutCancel(r.Context()), storageAdapter, keyType, key, rateConfig, charge.Load())
+		return
+	}
+
+	start := c.Clock.Now()
+	response := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	next.ServeHTTP(response, r)
+
+	status := response.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	units := c.ResponseCost(r, ResponseInfo{
+		Status:   status,
+		Bytes:    int64(response.BytesWritten()),
+		Duration: c.Clock.Now().Sub(start),
+	})
+	c.charge(context.WithoutCancel(r.Context()), storageAdapter, keyType, key, rateConfig, charge.Load()+max(units, 0))
+}
+
+// charge adds the units to every window of the key, in the storageAdapter that
+// admitted the request.
+func (c *RateLimiterConfig) charge(ctx context.Context, storageAdapter storage_adapters.StorageAdapter, keyType string, key string, rateConfig *RateLimiterRateConfig, units int64) {
+	if units <= 0 || rateConfig == nil {
+		return
+	}
+
+	for _, limit := range rateConfig.accessLimits() {
+		limit.Cost = units
+		start := time.Now()
+		_, err := storageAdapter.ChargeAccess(ctx, keyType, key, limit)
+		c.Metrics.observeStorage("charge_access", start, err)
+		// the other windows are still charged
+		if err != nil {
+			c.logChargeError(ctx, keyType, key, units, err)
+		}
+	}
+}
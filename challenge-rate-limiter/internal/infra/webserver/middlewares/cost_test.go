@@ -0,0 +1,175 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCostTestRouter(t *testing.T, config *RateLimiterConfig) http.Handler {
+	storageAdapter := newTestStorageAdapter(t)
+
+	config.LimitByIP = &RateLimiterRateConfig{Windows: []RateLimiterWindowConfig{{MaxRequests: 10, WindowMilliseconds: 60000}}}
+	config.StorageAdapter = storageAdapter
+
+	router := chi.NewRouter()
+	router.Use(NewRateLimiter(config))
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/report", func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		ChargeRequest(r.Context(), 4)
+	})
+	router.Get("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 3000)))
+	})
+	return router
+}
+
+func sendCostTestRequest(router http.Handler, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder
+}
+
+func TestGivenARouteCost_WhenRequestsArrive_ThenShouldConsumeTheCostFromTheDefaultQuota(t *testing.T) {
+	router := newCostTestRouter(t, &RateLimiterConfig{
+		Policies: []*RateLimiterPolicy{{Pattern: "/report", Cost: 4}},
+	})
+
+	response := sendCostTestRequest(router, "/report")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "6", response.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, sendCostTestRequest(router, "/report").Code)
+	// 2 units left, the report is denied but a cheaper request still fits
+	assert.Equal(t, http.StatusTooManyRequests, sendCostTestRequest(router, "/report").Code)
+	assert.Equal(t, http.StatusOK, sendCostTestRequest(router, "/").Code)
+}
+
+func TestGivenAHandlerCharge_WhenTheResponseIsWritten_ThenShouldChargeTheKey(t *testing.T) {
+	router := newCostTestRouter(t, &RateLimiterConfig{})
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, sendCostTestRequest(router, "/search").Code)
+	}
+
+	response := sendCostTestRequest(router, "/")
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+}
+
+func TestGivenAResponseCost_WhenTheResponseIsWritten_ThenShouldChargeByTheResponseSize(t *testing.T) {
+	var measured ResponseInfo
+	clock := storage_adapters.NewFakeClock(time.Now())
+	router := newCostTestRouter(t, &RateLimiterConfig{
+		Clock: clock,
+		ResponseCost: func(r *http.Request, response ResponseInfo) int64 {
+			measured = response
+			return response.Bytes / 1000
+		},
+	})
+
+	assert.Equal(t, http.StatusOK, sendCostTestRequest(router, "/download").Code)
+	assert.Equal(t, ResponseInfo{Status: http.StatusOK, Bytes: 3000}, measured)
+
+	response := sendCostTestRequest(router, "/")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "5", response.Header().Get("RateLimit-Remaining"))
+}
+
+func TestGivenACostInTheContext_WhenTheRequestArrives_ThenShouldReplaceTheRouteCost(t *testing.T) {
+	router := newCostTestRouter(t, &RateLimiterConfig{
+		Policies: []*RateLimiterPolicy{{Pattern: "/report", Cost: 4}},
+	})
+	withCost := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithRequestCost(r.Context(), 10)))
+		})
+	}
+
+	response := sendCostTestRequest(withCost(router), "/report")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+}
+
+// cancelAwareStorageAdapter fails the charges made with a done context, like
+// the adapters that call a server.
+type cancelAwareStorageAdapter struct {
+	storage_adapters.StorageAdapter
+}
+
+func (a cancelAwareStorageAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit storage_adapters.AccessLimit) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return a.StorageAdapter.ChargeAccess(ctx, keyType, key, limit)
+}
+
+func TestGivenAClientThatWentAway_WhenTheHandlerCharges_ThenShouldStillChargeTheKey(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{Windows: []RateLimiterWindowConfig{{MaxRequests: 10, WindowMilliseconds: 60000}}},
+		StorageAdapter: cancelAwareStorageAdapter{storageAdapter},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ChargeRequest(r.Context(), 9)
+		cancel()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+}
+
+func TestGivenTheLocalFailureMode_WhenTheHandlerChargesARequestAdmittedByTheFallback_ThenShouldChargeTheFallback(t *testing.T) {
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:              &RateLimiterRateConfig{Windows: []RateLimiterWindowConfig{{MaxRequests: 10, WindowMilliseconds: 60000}}},
+		StorageAdapter:         &failingStorageAdapter{},
+		FailureMode:            FailureModeLocal,
+		FallbackStorageAdapter: newTestStorageAdapter(t),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ChargeRequest(r.Context(), 9)
+	}))
+
+	assert.Equal(t, http.StatusOK, sendCostTestRequest(handler, "/").Code)
+	assert.Equal(t, http.StatusTooManyRequests, sendCostTestRequest(handler, "/").Code)
+}
+
+// windowFailingStorageAdapter fails the charges of one window.
+type windowFailingStorageAdapter struct {
+	storage_adapters.StorageAdapter
+	window time.Duration
+}
+
+func (a windowFailingStorageAdapter) ChargeAccess(ctx context.Context, keyType string, key string, limit storage_adapters.AccessLimit) (int64, error) {
+	if limit.Window == a.window {
+		return 0, errors.New("storage unavailable")
+	}
+	return a.StorageAdapter.ChargeAccess(ctx, keyType, key, limit)
+}
+
+func TestGivenAWindowWhoseChargeFails_WhenTheHandlerCharges_ThenShouldStillChargeTheOtherWindows(t *testing.T) {
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP: &RateLimiterRateConfig{
+			MaxRequestsPerSecond: 100,
+			Windows:              []RateLimiterWindowConfig{{MaxRequests: 10, WindowMilliseconds: 60000}},
+		},
+		StorageAdapter: windowFailingStorageAdapter{StorageAdapter: newTestStorageAdapter(t), window: time.Second},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ChargeRequest(r.Context(), 9)
+	}))
+
+	assert.Equal(t, http.StatusOK, sendCostTestRequest(handler, "/").Code)
+	assert.Equal(t, http.StatusTooManyRequests, sendCostTestRequest(handler, "/").Code)
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+)
+
+// processLogKeySecret keys the hashes of the logged keys when no LogKeySecret
+// is configured.
+var processLogKeySecret = newLogKeySecret()
+
+func newLogKeySecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// logDecision logs the outcome of a checked request. Allowed requests are only
+// logged at the debug level. The key is hashed so tokens and addresses don't
+// end up in the logs.
+func (c *RateLimiterConfig) logDecision(ctx context.Context, keyType string, key string, result *rateLimitResult) {
+	if result == nil {
+		return
+	}
+
+	message, level := "Request allowed", slog.LevelDebug
+	if !result.allowed {
+		message, level = "Request limited", slog.LevelInfo
+		if result.blockedUntil != nil {
+			message = "Request blocked"
+		}
+	}
+	if !c.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := append(c.keyAttrs(keyType, key),
+		slog.Int64("limit", result.limit),
+		slog.Int64("remaining", result.remaining),
+	)
+	if !result.allowed {
+		attrs = append(attrs, slog.Duration("retry_after", result.retryAfter))
+	}
+	c.Logger.LogAttrs(ctx, level, message, attrs...)
+}
+
+func (c *RateLimiterConfig) logError(ctx context.Context, keyType string, key string, err error) {
+	attrs := append(c.keyAttrs(keyType, key), slog.String("failure_mode", string(c.FailureMode)), slog.Any("error", err))
+	c.Logger.LogAttrs(ctx, slog.LevelError, "Error checking rate limit", attrs...)
+}
+
+func (c *RateLimiterConfig) logChargeError(ctx context.Context, keyType string, key string, units int64, err error) {
+	attrs := append(c.keyAttrs(keyType, key), slog.Int64("units", units), slog.Any("error", err))
+	c.Logger.LogAttrs(ctx, slog.LevelError, "Error charging rate limit", attrs...)
+}
+
+func (c *RateLimiterConfig) logRefundError(ctx context.Context, keyType string, key string, err error) {
+	attrs := append(c.keyAttrs(keyType, key), slog.Any("error", err))
+	c.Logger.LogAttrs(ctx, slog.LevelError, "Error refunding rate limit", attrs...)
+}
+
+func (c *RateLimiterConfig) logBlockError(ctx context.Context, keyType string, key string, err error) {
+	attrs := append(c.keyAttrs(keyType, key), slog.Any("error", err))
+	c.Logger.LogAttrs(ctx, slog.LevelError, "Error escalating block", attrs...)
+}
+
+func (c *RateLimiterConfig) keyAttrs(keyType string, key string) []slog.Attr {
+	baseKeyType, policy := splitKeyType(keyType)
+	return []slog.Attr{
+		slog.String("key_type", baseKeyType),
+		slog.String("key_hash", hashKey(c.LogKeySecret, key)),
+		slog.String("policy", policy),
+	}
+}
+
+// hashKey is a truncated HMAC-SHA256 of the key. A plain hash of a key taken
+// from a small space, such as an IPv4 address, is reversed by hashing every
+// candidate, which the secret prevents. An empty secret falls back to
+// processLogKeySecret.
+func hashKey(secret []byte, key string) string {
+	if len(secret) == 0 {
+		secret = processLogKeySecret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenALogger_WhenATokenIsLimited_ThenShouldLogTheDecisionWithAHashedKey(t *testing.T) {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer storageAdapter.Close()
+
+	output := &bytes.Buffer{}
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		LimitByToken:   &RateLimiterRateConfig{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 60000},
+		StorageAdapter: storageAdapter,
+		Logger:         slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("API_KEY", "secret-token")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	assert.NotContains(t, output.String(), "secret-token")
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	records := []map[string]any{}
+	for _, line := range lines {
+		record := map[string]any{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	assert.Equal(t, "DEBUG", records[0]["level"])
+	assert.Equal(t, "Request allowed", records[0]["msg"])
+	assert.Equal(t, float64(0), records[0]["remaining"])
+	assert.Equal(t, "INFO", records[1]["level"])
+	assert.Equal(t, "Request blocked", records[1]["msg"])
+	assert.Equal(t, "TOKEN", records[1]["key_type"])
+	assert.Equal(t, hashKey(nil, "secret-token"), records[1]["key_hash"])
+	assert.Equal(t, "", records[1]["policy"])
+}
+
+func TestGivenAFailingStorage_WhenARequestArrives_ThenShouldLogTheError(t *testing.T) {
+	output := &bytes.Buffer{}
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		LimitByToken:   &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		StorageAdapter: &failingStorageAdapter{},
+		FailureMode:    FailureModeOpen,
+		Logger:         slog.New(slog.NewJSONHandler(output, nil)),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	record := map[string]any{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &record))
+	assert.Equal(t, "ERROR", record["level"])
+	assert.Equal(t, "IP", record["key_type"])
+	assert.Equal(t, "open", record["failure_mode"])
+	assert.Equal(t, "storage unavailable", record["error"])
+}
+
+func TestGivenALogKeySecret_WhenHashingAKey_ThenShouldUseAnHMACOfTheSecret(t *testing.T) {
+	config := &RateLimiterConfig{LogKeySecret: []byte("replica-secret")}
+
+	attrs := config.keyAttrs("IP:login", "10.0.0.1")
+	assert.Equal(t, "8d3673ef8f5a16b7", attrs[1].Value.String())
+	assert.Equal(t, attrs[1].Value.String(), hashKey([]byte("replica-secret"), "10.0.0.1"))
+
+	// the unsalted hash of the address could be found by hashing every IPv4
+	unsalted := sha256.Sum256([]byte("10.0.0.1"))
+	assert.NotEqual(t, hex.EncodeToString(unsalted[:8]), hashKey(nil, "10.0.0.1"))
+	assert.NotEqual(t, hashKey([]byte("other-secret"), "10.0.0.1"), attrs[1].Value.String())
+}
@@ -0,0 +1,306 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// KeyExtractor identifies who is being rate limited. An empty key means the
+// request carries no identity for this extractor.
+type KeyExtractor interface {
+	ExtractKey(r *http.Request) string
+}
+
+type KeyExtractorFunc func(r *http.Request) string
+
+func (f KeyExtractorFunc) ExtractKey(r *http.Request) string {
+	return f(r)
+}
+
+func NewHeaderKeyExtractor(header string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return r.Header.Get(header)
+	})
+}
+
+func NewRemoteAddrKeyExtractor() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return remoteHost(r)
+	})
+}
+
+// NewForwardedKeyExtractor returns the client IP taken from the Forwarded or
+// X-Forwarded-For headers. The headers are only trusted when the request comes
+// from one of the trustedProxies, and the chain is walked from the right so a
+// client can't spoof its address by prepending entries.
+func NewForwardedKeyExtractor(trustedProxies []*net.IPNet) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		host := remoteHost(r)
+		if !isTrustedProxy(host, trustedProxies) {
+			return host
+		}
+
+		chain := forwardedChain(r)
+		for i := len(chain) - 1; i >= 0; i-- {
+			if !isTrustedProxy(chain[i], trustedProxies) {
+				return chain[i]
+			}
+		}
+
+		if len(chain) > 0 {
+			return chain[0]
+		}
+		return host
+	})
+}
+
+// NewJWTClaimKeyExtractor uses a claim of the bearer token as key. When secret
+// is set the token must carry a valid HS256 signature, otherwise the claims are
+// read as is and the token must be validated before reaching the rate limiter.
+func NewJWTClaimKeyExtractor(claim string, secret []byte) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return ""
+		}
+
+		claims, err := parseJWTClaims(strings.TrimSpace(token), secret)
+		if err != nil {
+			return ""
+		}
+
+		switch value := claims[claim].(type) {
+		case string:
+			return value
+		case float64, bool:
+			return fmt.Sprint(value)
+		}
+		return ""
+	})
+}
+
+// NewRoutePatternKeyExtractor uses the chi route pattern (e.g. "/users/{id}")
+// matched by the request.
+func NewRoutePatternKeyExtractor() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return routePattern(r)
+	})
+}
+
+// NewCompositeKeyExtractor joins the keys of every extractor. The composite key
+// is empty when any of the extractors has no key.
+func NewCompositeKeyExtractor(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		keys := make([]string, 0, len(extractors))
+		for _, extractor := range extractors {
+			key := extractor.ExtractKey(r)
+			if key == "" {
+				return ""
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, "|")
+	})
+}
+
+// ParseKeyExtractor builds an extractor from a comma separated list of sources,
+// combined into a composite key when more than one is given. Sources are "ip",
+// "forwarded", "route", "header:<name>" and "jwt:<claim>".
+func ParseKeyExtractor(value string, trustedProxies []*net.IPNet, jwtSecret []byte) (KeyExtractor, error) {
+	extractors := []KeyExtractor{}
+	for _, source := range strings.Split(value, ",") {
+		source = strings.TrimSpace(source)
+		kind, argument, _ := strings.Cut(source, ":")
+
+		switch {
+		case source == "":
+			continue
+		case kind == "ip":
+			extractors = append(extractors, NewRemoteAddrKeyExtractor())
+		case kind == "forwarded":
+			extractors = append(extractors, NewForwardedKeyExtractor(trustedProxies))
+		case kind == "route":
+			extractors = append(extractors, NewRoutePatternKeyExtractor())
+		case kind == "header" && argument != "":
+			extractors = append(extractors, NewHeaderKeyExtractor(argument))
+		case kind == "jwt" && argument != "":
+			extractors = append(extractors, NewJWTClaimKeyExtractor(argument, jwtSecret))
+		default:
+			return nil, fmt.Errorf("invalid key extractor %q", source)
+		}
+	}
+
+	switch len(extractors) {
+	case 0:
+		return nil, fmt.Errorf("no key extractor in %q", value)
+	case 1:
+		return extractors[0], nil
+	}
+	return NewCompositeKeyExtractor(extractors...), nil
+}
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDR ranges.
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrustedProxy(host string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedChain lists the client addresses of the Forwarded header, falling
+// back to X-Forwarded-For, from the original client to the closest proxy.
+func forwardedChain(r *http.Request) []string {
+	chain := []string{}
+	for _, header := range r.Header.Values("Forwarded") {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					chain = append(chain, forwardedHost(value))
+				}
+			}
+		}
+	}
+
+	if len(chain) > 0 {
+		return chain
+	}
+
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(header, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, forwardedHost(address))
+			}
+		}
+	}
+	return chain
+}
+
+func forwardedHost(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return strings.Trim(value, "[]")
+}
+
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+
+	// middlewares registered with Use run before chi resolves the route
+	if rctx.Routes == nil {
+		return ""
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, path) {
+		return ""
+	}
+	return tctx.RoutePattern()
+}
+
+func parseJWTClaims(token string, secret []byte) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	if len(secret) > 0 {
+		var header struct {
+			Alg string `json:"alg"`
+		}
+		if err := decodeJWTSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+			return nil, fmt.Errorf("unsupported token algorithm")
+		}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenAnUntrustedRemoteAddr_WhenExtractingForwardedKey_ThenShouldIgnoreTheHeaders(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	assert.Equal(t, "203.0.113.7", NewForwardedKeyExtractor(proxies).ExtractKey(r))
+}
+
+func TestGivenATrustedProxyChain_WhenExtractingForwardedKey_ThenShouldReturnTheFirstUntrustedHop(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8,192.168.1.10")
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 198.51.100.1, 192.168.1.10")
+	assert.Equal(t, "198.51.100.1", NewForwardedKeyExtractor(proxies).ExtractKey(r))
+
+	r.Header.Set("Forwarded", `for="[2001:db8::17]:4711";proto=https, for=10.9.9.9`)
+	assert.Equal(t, "2001:db8::17", NewForwardedKeyExtractor(proxies).ExtractKey(r))
+}
+
+func TestGivenASignedJWT_WhenExtractingAClaim_ThenShouldValidateTheSignature(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-42"}`))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header + "." + payload))
+	token := header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	assert.Equal(t, "user-42", NewJWTClaimKeyExtractor("sub", []byte("secret")).ExtractKey(r))
+	assert.Equal(t, "", NewJWTClaimKeyExtractor("sub", []byte("other")).ExtractKey(r))
+	assert.Equal(t, "user-42", NewJWTClaimKeyExtractor("sub", nil).ExtractKey(r))
+}
+
+func TestGivenACompositeSpec_WhenExtractingTheKey_ThenShouldJoinIPAndRoutePattern(t *testing.T) {
+	extractor, err := ParseKeyExtractor("ip,route", nil, nil)
+	assert.Nil(t, err)
+
+	var key string
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key = extractor.ExtractKey(r)
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/users/10", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "203.0.113.7|/users/{id}", key)
+}
+
+func TestGivenAnInvalidSpec_WhenParsingAKeyExtractor_ThenShouldReceiveAnError(t *testing.T) {
+	_, err := ParseKeyExtractor("header:", nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+// LiveRateLimiter serves the rate limiter middleware from a RateLimiterConfig
+// that can be swapped at runtime. Every request reads a single snapshot of the
+// config, so in-flight requests are never affected by a reload.
+type LiveRateLimiter struct {
+	mutex      sync.Mutex
+	base       *RateLimiterConfig
+	policies   []*RateLimiterPolicy
+	policyFile *RateLimiterPolicyFile
+	allowList  AccessList
+	denyList   AccessList
+	current    atomic.Pointer[RateLimiterConfig]
+}
+
+func NewLiveRateLimiter(config *RateLimiterConfig) *LiveRateLimiter {
+	limiter := &LiveRateLimiter{base: config}
+	limiter.current.Store(limiter.build())
+	return limiter
+}
+
+func (l *LiveRateLimiter) Middleware(next http.Handler) http.Handler {
+	return rateLimiter(l, next)
+}
+
+func (l *LiveRateLimiter) Config() *RateLimiterConfig {
+	return l.current.Load()
+}
+
+// AddPolicy registers a route policy that is kept across policy file reloads.
+func (l *LiveRateLimiter) AddPolicy(policy *RateLimiterPolicy) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.policies = append(l.policies, policy)
+	l.current.Store(l.build())
+}
+
+// SetAccessLists replaces the lists set by the previous call. They are kept
+// across policy file reloads and added to the lists of the RateLimiterConfig
+// and of the policy file.
+func (l *LiveRateLimiter) SetAccessLists(allowList AccessList, denyList AccessList) error {
+	for name, list := range map[string]AccessList{"allow list": allowList, "deny list": denyList} {
+		if err := list.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.allowList, l.denyList = allowList, denyList
+	l.current.Store(l.build())
+	return nil
+}
+
+// ApplyPolicyFile replaces the limits defined by the previous policy file.
+func (l *LiveRateLimiter) ApplyPolicyFile(policyFile *RateLimiterPolicyFile) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.policyFile = policyFile
+	l.current.Store(l.build())
+}
+
+func (l *LiveRateLimiter) build() *RateLimiterConfig {
+	config := *l.base
+	if config.TokenKeyExtractor == nil {
+		config.TokenKeyExtractor = NewHeaderKeyExtractor("API_KEY")
+	}
+	if config.IPKeyExtractor == nil {
+		config.IPKeyExtractor = NewRemoteAddrKeyExtractor()
+	}
+	config.clientIPExtractor = NewForwardedKeyExtractor(config.TrustedProxies)
+	if config.Clock == nil {
+		config.Clock = storage_adapters.SystemClock
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	if config.ConcurrencyAdapter == nil {
+		config.ConcurrencyAdapter, _ = config.StorageAdapter.(storage_adapters.ConcurrencyAdapter)
+	}
+
+	policies := []*RateLimiterPolicy{}
+	allowList, denyList := l.base.AllowList.merge(l.allowList), l.base.DenyList.merge(l.denyList)
+	if l.policyFile != nil {
+		if l.policyFile.LimitByIP != nil {
+			config.LimitByIP = l.policyFile.LimitByIP
+		}
+		if l.policyFile.LimitByToken != nil {
+			config.LimitByToken = l.policyFile.LimitByToken
+		}
+		if l.policyFile.CustomTokens != nil {
+			config.CustomTokens = &l.policyFile.CustomTokens
+		}
+		policies = append(policies, l.policyFile.Routes...)
+		allowList, denyList = allowList.merge(l.policyFile.Allow), denyList.merge(l.policyFile.Deny)
+	}
+	config.allowed, config.denied = newAccessMatcher(allowList), newAccessMatcher(denyList)
+
+	policies = append(policies, l.base.Policies...)
+	config.Policies = append(policies, l.policies...)
+	return &config
+}
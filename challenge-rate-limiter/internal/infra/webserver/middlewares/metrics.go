@@ -0,0 +1,184 @@
+package middlewares
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	decisionAllowed = "allowed"
+	decisionLimited = "limited"
+	decisionBlocked = "blocked"
+	// the decisions of the requests in the AllowList or the DenyList
+	decisionAllowlisted = "allowlisted"
+	decisionDenied      = "denied"
+)
+
+// blockedKeysCacheTTL is how long the blocks listed for the blocked keys gauge
+// are reused, since listing them walks every key of the StorageAdapter.
+const blockedKeysCacheTTL = 30 * time.Second
+
+// Metrics are the Prometheus metrics of the rate limiter. A nil *Metrics
+// records nothing.
+type Metrics struct {
+	requests           *prometheus.CounterVec
+	concurrencyLimited *prometheus.CounterVec
+	storageDuration    *prometheus.HistogramVec
+	storageErrors      *prometheus.CounterVec
+}
+
+// NewMetrics registers the rate limiter metrics. The blocked keys gauge is
+// read from the StorageAdapter at most once every blockedKeysCacheTTL.
+func NewMetrics(registerer prometheus.Registerer, storageAdapter storage_adapters.StorageAdapter) (*Metrics, error) {
+	metrics := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limiter_requests_total",
+			Help: "Requests checked by the rate limiter by key type, policy and decision (allowed, limited, blocked, allowlisted or denied).",
+		}, []string{"key_type", "policy", "decision"}),
+		concurrencyLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limiter_concurrency_limited_total",
+			Help: "Requests rejected because every concurrency slot of the key was taken, by key type and policy.",
+		}, []string{"key_type", "policy"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rate_limiter_storage_duration_seconds",
+			Help:    "Latency of the storage adapter calls.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limiter_storage_errors_total",
+			Help: "Failed storage adapter calls.",
+		}, []string{"operation"}),
+	}
+
+	collectors := []prometheus.Collector{metrics.requests, metrics.concurrencyLimited, metrics.storageDuration, metrics.storageErrors}
+	if storageAdapter != nil {
+		collectors = append(collectors, &blockedKeysCollector{
+			storageAdapter: storageAdapter,
+			now:            time.Now,
+			description: prometheus.NewDesc(
+				"rate_limiter_blocked_keys",
+				"Keys currently blocked by key type and policy.",
+				[]string{"key_type", "policy"}, nil,
+			),
+		})
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+func (m *Metrics) observeDecision(keyType string, result *rateLimitResult) {
+	if m == nil {
+		return
+	}
+
+	decision := decisionAllowed
+	if result != nil && !result.allowed {
+		decision = decisionLimited
+		if result.blockedUntil != nil {
+			decision = decisionBlocked
+		}
+	}
+	baseKeyType, policy := splitKeyType(keyType)
+	m.requests.WithLabelValues(baseKeyType, policy, decision).Inc()
+}
+
+func (m *Metrics) observeListed(keyType string, decision string) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(keyType, "", decision).Inc()
+}
+
+func (m *Metrics) observeConcurrencyLimited(keyType string) {
+	if m == nil {
+		return
+	}
+
+	baseKeyType, policy := splitKeyType(keyType)
+	m.concurrencyLimited.WithLabelValues(baseKeyType, policy).Inc()
+}
+
+func (m *Metrics) observeStorage(operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.storageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// splitKeyType separates the policy namespace added by policyKeyType.
+func splitKeyType(keyType string) (string, string) {
+	baseKeyType, policy, _ := strings.Cut(keyType, ":")
+	return baseKeyType, policy
+}
+
+type blockedKeysCollector struct {
+	storageAdapter storage_adapters.StorageAdapter
+	description    *prometheus.Desc
+	now            func() time.Time
+
+	mutex    sync.Mutex
+	blocks   []storage_adapters.Block
+	listedAt time.Time
+}
+
+func (c *blockedKeysCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.description
+}
+
+func (c *blockedKeysCollector) Collect(ch chan<- prometheus.Metric) {
+	blocks, err := c.listBlocks()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.description, err)
+		return
+	}
+
+	now := c.now()
+	counts := map[[2]string]int{}
+	for _, block := range blocks {
+		// blocks listed earlier may have expired since
+		if !block.BlockedUntil.After(now) {
+			continue
+		}
+		baseKeyType, policy := splitKeyType(block.KeyType)
+		counts[[2]string{baseKeyType, policy}]++
+	}
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.description, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+}
+
+// listBlocks returns the blocks listed less than blockedKeysCacheTTL ago, or
+// lists them again. Concurrent scrapes wait for a single listing.
+func (c *blockedKeysCollector) listBlocks() ([]storage_adapters.Block, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.blocks != nil && c.now().Sub(c.listedAt) < blockedKeysCacheTTL {
+		return c.blocks, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	blocks, err := c.storageAdapter.ListBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.blocks, c.listedAt = blocks, c.now()
+	return blocks, nil
+}
@@ -0,0 +1,116 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenMetrics_WhenRequestsAreLimited_ThenShouldCountTheDecisionsAndBlockedKeys(t *testing.T) {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	defer storageAdapter.Close()
+
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry, storageAdapter)
+	assert.Nil(t, err)
+
+	limiter := NewLiveRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 2, BlockTimeMilliseconds: 60000},
+		LimitByToken:   &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		StorageAdapter: storageAdapter,
+		Metrics:        metrics,
+	})
+	limiter.AddPolicy(&RateLimiterPolicy{Name: "login", Method: "POST", Pattern: "/login", LimitByIP: &RateLimiterRateConfig{MaxRequestsPerSecond: 1}})
+	handler := chi.NewRouter()
+	handler.Use(limiter.Middleware)
+	handler.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler.Post("/login", func(w http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil))
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("API_KEY", "XYZ")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.requests.WithLabelValues("IP", "", decisionAllowed)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("IP", "", decisionBlocked)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("IP", "login", decisionAllowed)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("TOKEN", "", decisionAllowed)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("TOKEN", "", decisionLimited)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.storageDuration))
+	assert.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP rate_limiter_blocked_keys Keys currently blocked by key type and policy.
+# TYPE rate_limiter_blocked_keys gauge
+rate_limiter_blocked_keys{key_type="IP",policy=""} 1
+`), "rate_limiter_blocked_keys"))
+}
+
+func TestGivenMetrics_WhenTheStorageFails_ThenShouldCountTheError(t *testing.T) {
+	metrics, err := NewMetrics(prometheus.NewRegistry(), nil)
+	assert.Nil(t, err)
+
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		LimitByToken:   &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+		StorageAdapter: &failingStorageAdapter{},
+		Metrics:        metrics,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.storageErrors.WithLabelValues("check_access")))
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.requests))
+}
+
+type countingStorageAdapter struct {
+	storage_adapters.StorageAdapter
+	listBlocksCalls int
+}
+
+func (a *countingStorageAdapter) ListBlocks(ctx context.Context) ([]storage_adapters.Block, error) {
+	a.listBlocksCalls++
+	return a.StorageAdapter.ListBlocks(ctx)
+}
+
+func TestGivenBlockedKeys_WhenScrapedRepeatedly_ThenShouldListTheBlocksOncePerCacheTTL(t *testing.T) {
+	clock := storage_adapters.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	memoryAdapter, err := storage_adapters.InitMemoryAdapterWithConfig(storage_adapters.MemoryAdapterConfig{Clock: clock})
+	assert.Nil(t, err)
+	defer memoryAdapter.Close()
+	storageAdapter := &countingStorageAdapter{StorageAdapter: memoryAdapter}
+
+	collector := &blockedKeysCollector{
+		storageAdapter: storageAdapter,
+		description:    prometheus.NewDesc("rate_limiter_blocked_keys", "", []string{"key_type", "policy"}, nil),
+		now:            clock.Now,
+	}
+	memoryAdapter.AddBlock(context.Background(), "IP", "10.0.0.1", 10000)
+	memoryAdapter.AddBlock(context.Background(), "IP", "10.0.0.2", 60000)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector))
+	memoryAdapter.AddBlock(context.Background(), "IP", "10.0.0.3", 60000)
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector))
+	assert.Equal(t, 1, storageAdapter.listBlocksCalls)
+
+	// the cached block of 10.0.0.1 expires before the next listing
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector))
+	assert.Equal(t, 1, storageAdapter.listBlocksCalls)
+
+	clock.Advance(blockedKeysCacheTTL)
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector))
+	assert.Equal(t, 2, storageAdapter.listBlocksCalls)
+}
@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RateLimiterPolicy overrides the limits of the requests matching a chi route
+// Pattern and, optionally, a Method. Each policy counts its accesses in its
+// own namespace, so a route never consumes the quota of another one. Limits
+// left nil fall back to the RateLimiterConfig ones. Cost is the number of
+// requests each request of the route counts as; a policy that only sets a Cost
+// consumes the quota of the RateLimiterConfig limits instead of its own.
+type RateLimiterPolicy struct {
+	Name         string                 `json:"name"`
+	Method       string                 `json:"method"`
+	Pattern      string                 `json:"pattern"`
+	LimitByIP    *RateLimiterRateConfig `json:"limitByIP"`
+	LimitByToken *RateLimiterRateConfig `json:"limitByToken"`
+	Cost         int64                  `json:"cost,omitempty"`
+}
+
+func (p *RateLimiterPolicy) hasLimits() bool {
+	return p.LimitByIP != nil || p.LimitByToken != nil
+}
+
+func (p *RateLimiterPolicy) matches(method string, pattern string) bool {
+	return p.Pattern == pattern && (p.Method == "" || strings.EqualFold(p.Method, method))
+}
+
+func (p *RateLimiterPolicy) namespace() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	if p.Method == "" {
+		return p.Pattern
+	}
+	return strings.ToUpper(p.Method) + " " + p.Pattern
+}
+
+func (c *RateLimiterConfig) policyFor(r *http.Request) *RateLimiterPolicy {
+	if len(c.Policies) == 0 {
+		return nil
+	}
+
+	return c.policyMatching(r.Method, routePattern(r))
+}
+
+func (c *RateLimiterConfig) policyMatching(method string, pattern string) *RateLimiterPolicy {
+	if pattern == "" {
+		return nil
+	}
+
+	var matched *RateLimiterPolicy
+	for _, policy := range c.Policies {
+		if !policy.matches(method, pattern) {
+			continue
+		}
+		// a policy bound to the method wins over one for any method
+		if matched == nil || matched.Method == "" {
+			matched = policy
+		}
+	}
+	return matched
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPolicyTestRouter(t *testing.T, policies []*RateLimiterPolicy) http.Handler {
+	storageAdapter := newTestStorageAdapter(t)
+
+	customTokens := map[string]*RateLimiterRateConfig{}
+	router := chi.NewRouter()
+	router.Use(NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 100},
+		LimitByToken:   &RateLimiterRateConfig{MaxRequestsPerSecond: 100},
+		StorageAdapter: storageAdapter,
+		CustomTokens:   &customTokens,
+		Policies:       policies,
+	}))
+
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	router.Get("/", handler)
+	router.Post("/login", handler)
+	return router
+}
+
+func sendPolicyTestRequest(router http.Handler, method string, path string) int {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder.Code
+}
+
+func TestGivenARoutePolicy_WhenTheRouteLimitIsExceeded_ThenOtherRoutesShouldKeepTheirQuota(t *testing.T) {
+	router := newPolicyTestRouter(t, []*RateLimiterPolicy{{
+		Method:    "POST",
+		Pattern:   "/login",
+		LimitByIP: &RateLimiterRateConfig{Windows: []RateLimiterWindowConfig{{MaxRequests: 2, WindowMilliseconds: 60000}}},
+	}})
+
+	assert.Equal(t, http.StatusOK, sendPolicyTestRequest(router, "POST", "/login"))
+	assert.Equal(t, http.StatusOK, sendPolicyTestRequest(router, "POST", "/login"))
+	assert.Equal(t, http.StatusTooManyRequests, sendPolicyTestRequest(router, "POST", "/login"))
+	assert.Equal(t, http.StatusOK, sendPolicyTestRequest(router, "GET", "/"))
+}
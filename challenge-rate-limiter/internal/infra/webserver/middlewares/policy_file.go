@@ -0,0 +1,157 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+const policyFileReloadDelay = 100 * time.Millisecond
+
+// RateLimiterPolicyFile holds the limits read from a policy file. The IP and
+// token limits replace the ones of the RateLimiterConfig when present, while
+// the allow and deny lists are added to its AllowList and DenyList.
+type RateLimiterPolicyFile struct {
+	LimitByIP    *RateLimiterRateConfig            `json:"limitByIP"`
+	LimitByToken *RateLimiterRateConfig            `json:"limitByToken"`
+	CustomTokens map[string]*RateLimiterRateConfig `json:"customTokens"`
+	Routes       []*RateLimiterPolicy              `json:"routes"`
+	Allow        AccessList                        `json:"allow"`
+	Deny         AccessList                        `json:"deny"`
+}
+
+// LoadRateLimiterPolicyFile reads a YAML or JSON policy file. YAML documents
+// use the same keys as the JSON ones.
+func LoadRateLimiterPolicyFile(path string) (*RateLimiterPolicyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var document interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+		}
+		if data, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+		}
+	}
+
+	policyFile := &RateLimiterPolicyFile{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policyFile); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+
+	if err := policyFile.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return policyFile, nil
+}
+
+func (f *RateLimiterPolicyFile) validate() error {
+	for name, rateConfig := range map[string]*RateLimiterRateConfig{"limitByIP": f.LimitByIP, "limitByToken": f.LimitByToken} {
+		if err := rateConfig.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for token, rateConfig := range f.CustomTokens {
+		if rateConfig == nil {
+			return fmt.Errorf("custom token %s has no limits", token)
+		}
+		if err := rateConfig.validate(); err != nil {
+			return fmt.Errorf("custom token %s: %w", token, err)
+		}
+	}
+	for i, policy := range f.Routes {
+		if policy == nil || policy.Pattern == "" {
+			return fmt.Errorf("route %d has no pattern", i)
+		}
+		if policy.Cost < 0 {
+			return fmt.Errorf("route %s: cost can't be negative", policy.namespace())
+		}
+		for _, rateConfig := range []*RateLimiterRateConfig{policy.LimitByIP, policy.LimitByToken} {
+			if err := rateConfig.validate(); err != nil {
+				return fmt.Errorf("route %s: %w", policy.namespace(), err)
+			}
+		}
+	}
+	for name, list := range map[string]AccessList{"allow": f.Allow, "deny": f.Deny} {
+		if err := list.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// WatchPolicyFile applies the policy file to the limiter and reloads it every
+// time it changes. A file that fails to load is logged and the previous policy
+// is kept. The returned function stops watching.
+func WatchPolicyFile(path string, limiter *LiveRateLimiter) (func() error, error) {
+	policyFile, err := LoadRateLimiterPolicyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	limiter.ApplyPolicyFile(policyFile)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// editors usually replace the file, so the directory is watched instead
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		var reload *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+
+				// writes usually come in bursts, so only the last one is loaded
+				if reload != nil {
+					reload.Stop()
+				}
+				reload = time.AfterFunc(policyFileReloadDelay, func() {
+					reloadPolicyFile(path, limiter)
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				limiter.Config().Logger.Error("Error watching policy file", "path", path, "error", err)
+			}
+		}
+	}()
+
+	return watcher.Close, nil
+}
+
+func reloadPolicyFile(path string, limiter *LiveRateLimiter) {
+	policyFile, err := LoadRateLimiterPolicyFile(path)
+	if err != nil {
+		limiter.Config().Logger.Error("Error reloading policy file, keeping the previous policy", "path", path, "error", err)
+		return
+	}
+
+	limiter.ApplyPolicyFile(policyFile)
+	limiter.Config().Logger.Info("Policy file reloaded", "path", path)
+}
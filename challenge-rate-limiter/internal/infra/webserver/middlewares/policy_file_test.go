@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenAYAMLPolicyFile_WhenLoadingIt_ThenShouldReadTheRoutePolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	content := "routes:\n  - method: POST\n    pattern: /login\n    limitByIP:\n      windows:\n        - maxRequests: 5\n          windowMilliseconds: 60000\n"
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+
+	policyFile, err := LoadRateLimiterPolicyFile(path)
+	assert.Nil(t, err)
+	assert.Len(t, policyFile.Routes, 1)
+	assert.Equal(t, "POST /login", policyFile.Routes[0].namespace())
+	assert.Equal(t, int64(5), policyFile.Routes[0].LimitByIP.Windows[0].MaxRequests)
+}
+
+func TestGivenAPolicyWithoutLimits_WhenLoadingThePolicyFile_ThenShouldReceiveAnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"routes":[{"pattern":"/","limitByIP":{"blockTimeMilliseconds":10}}]}`), 0o600))
+
+	_, err := LoadRateLimiterPolicyFile(path)
+	assert.Error(t, err)
+}
+
+func TestGivenAWatchedPolicyFile_WhenItChanges_ThenShouldSwapTheLiveConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"customTokens":{"ABC":{"maxRequestsPerSecond":20}}}`), 0o600))
+
+	limiter := NewLiveRateLimiter(&RateLimiterConfig{LimitByToken: &RateLimiterRateConfig{MaxRequestsPerSecond: 10}})
+	stop, err := WatchPolicyFile(path, limiter)
+	assert.Nil(t, err)
+	defer stop()
+
+	tokenConfig, ok := limiter.Config().customToken("ABC")
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), tokenConfig.MaxRequestsPerSecond)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"customTokens":{"ABC":{"maxRequestsPerSecond":50}}}`), 0o600))
+	assert.Eventually(t, func() bool {
+		tokenConfig, ok := limiter.Config().customToken("ABC")
+		return ok && tokenConfig.MaxRequestsPerSecond == 50
+	}, 2*time.Second, 10*time.Millisecond)
+
+	previous := limiter.Config()
+	assert.Nil(t, os.WriteFile(path, []byte(`{"customTokens":{"ABC":{"maxRequestsPerSecond":-1}}}`), 0o600))
+	time.Sleep(3 * policyFileReloadDelay)
+	assert.Same(t, previous, limiter.Config())
+	assert.Equal(t, int64(10), limiter.Config().LimitByToken.MaxRequestsPerSecond)
+}
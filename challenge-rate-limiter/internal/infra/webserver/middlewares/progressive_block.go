@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+const (
+	defaultMaxBlockTime = time.Hour
+	defaultBlockDecay   = 24 * time.Hour
+)
+
+func (c *RateLimiterRateConfig) escalatesBlocks() bool {
+	return c.BlockMultiplier > 1 && c.BlockTimeMilliseconds > 0
+}
+
+func (c *RateLimiterRateConfig) blockDecay() time.Duration {
+	if c.BlockDecayMilliseconds > 0 {
+		return time.Duration(c.BlockDecayMilliseconds) * time.Millisecond
+	}
+	return defaultBlockDecay
+}
+
+// blockMilliseconds is the block time of the offenses-th consecutive block.
+func (c *RateLimiterRateConfig) blockMilliseconds(offenses int64) int64 {
+	maxBlockTime := c.MaxBlockTimeMilliseconds
+	if maxBlockTime <= 0 {
+		maxBlockTime = defaultMaxBlockTime.Milliseconds()
+	}
+
+	blockTime := float64(c.BlockTimeMilliseconds) * math.Pow(c.BlockMultiplier, float64(offenses-1))
+	return max(c.BlockTimeMilliseconds, int64(math.Min(blockTime, float64(maxBlockTime))))
+}
+
+// escalateBlock counts the block just applied to the key as an offense and
+// extends it according to the previous offenses. The block is kept as is when
+// the StorageAdapter fails.
+func (c *RateLimiterConfig) escalateBlock(ctx context.Context, storageAdapter storage_adapters.StorageAdapter, keyType string, key string, rateConfig *RateLimiterRateConfig, block *time.Time) *time.Time {
+	start := time.Now()
+	offenses, err := storageAdapter.AddOffense(ctx, keyType, key, rateConfig.blockDecay())
+	c.Metrics.observeStorage("add_offense", start, err)
+	if err != nil {
+		c.logBlockError(ctx, keyType, key, err)
+		return block
+	}
+
+	blockTime := rateConfig.blockMilliseconds(offenses)
+	if blockTime <= rateConfig.BlockTimeMilliseconds {
+		return block
+	}
+
+	start = time.Now()
+	escalated, err := storageAdapter.AddBlock(ctx, keyType, key, blockTime)
+	c.Metrics.observeStorage("add_block", start, err)
+	if err != nil {
+		c.logBlockError(ctx, keyType, key, err)
+		return block
+	}
+
+	c.Logger.LogAttrs(ctx, slog.LevelInfo, "Block escalated",
+		append(c.keyAttrs(keyType, key), slog.Int64("offenses", offenses), slog.Duration("block_time", time.Duration(blockTime)*time.Millisecond))...)
+	return escalated
+}
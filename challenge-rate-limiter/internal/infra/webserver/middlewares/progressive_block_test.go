@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenARepeatOffender_WhenItIsBlockedAgainWithinTheDecay_ThenShouldEscalateTheBlockUpToTheCap(t *testing.T) {
+	clock := storage_adapters.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	storageAdapter, err := storage_adapters.InitMemoryAdapterWithConfig(storage_adapters.MemoryAdapterConfig{Clock: clock})
+	assert.Nil(t, err)
+
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP: &RateLimiterRateConfig{
+			MaxRequestsPerSecond:     1,
+			BlockTimeMilliseconds:    1000,
+			BlockMultiplier:          2,
+			MaxBlockTimeMilliseconds: 3000,
+			BlockDecayMilliseconds:   10000,
+		},
+		StorageAdapter: storageAdapter,
+		Clock:          clock,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	sendRequest := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		return recorder
+	}
+
+	for _, blockTime := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		assert.Equal(t, http.StatusOK, sendRequest().Code)
+		recorder := sendRequest()
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, strconv.Itoa(int(blockTime.Seconds())), recorder.Header().Get("Retry-After"))
+
+		clock.Advance(blockTime - time.Millisecond)
+		assert.Equal(t, http.StatusTooManyRequests, sendRequest().Code)
+		clock.Advance(time.Millisecond)
+	}
+
+	// the offenses are forgotten after the decay
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, http.StatusOK, sendRequest().Code)
+	assert.Equal(t, "1", sendRequest().Header().Get("Retry-After"))
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func writeRateLimitHeaders(w http.ResponseWriter, config *RateLimiterConfig, result *rateLimitResult) {
+	headers := map[string]string{
+		"Limit":     strconv.FormatInt(result.limit, 10),
+		"Remaining": strconv.FormatInt(result.remaining, 10),
+		"Reset":     strconv.FormatInt(deltaSeconds(result.reset), 10),
+	}
+
+	for name, value := range headers {
+		w.Header().Set("RateLimit-"+name, value)
+		if config.XRateLimitHeaders {
+			w.Header().Set("X-RateLimit-"+name, value)
+		}
+	}
+
+	if !result.allowed {
+		w.Header().Set("Retry-After", strconv.FormatInt(max(deltaSeconds(result.retryAfter), 1), 10))
+	}
+}
+
+func deltaSeconds(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+type expectedHeaders struct {
+	status     int
+	remaining  string
+	reset      string
+	retryAfter string
+}
+
+func TestGivenEachAlgorithm_WhenRequestsArrive_ThenShouldSendTheRateLimitHeaders(t *testing.T) {
+	// 2 requests per 10 seconds, so the token bucket and GCRA emit one every 5s
+	tests := []struct {
+		algorithm storage_adapters.Algorithm
+		responses []expectedHeaders
+	}{
+		{storage_adapters.AlgorithmSlidingLog, []expectedHeaders{{200, "1", "10", ""}, {200, "0", "10", ""}, {429, "0", "10", "10"}}},
+		{storage_adapters.AlgorithmFixedWindow, []expectedHeaders{{200, "1", "6", ""}, {200, "0", "6", ""}, {429, "0", "6", "6"}}},
+		{storage_adapters.AlgorithmTokenBucket, []expectedHeaders{{200, "1", "5", ""}, {200, "0", "10", ""}, {429, "0", "10", "5"}}},
+		{storage_adapters.AlgorithmGCRA, []expectedHeaders{{200, "1", "5", ""}, {200, "0", "10", ""}, {429, "0", "10", "5"}}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(string(test.algorithm), func(t *testing.T) {
+			// 4 seconds into a fixed window of 10 seconds
+			clock := storage_adapters.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 4, 0, time.UTC))
+			storageAdapter, err := storage_adapters.InitMemoryAdapterWithConfig(storage_adapters.MemoryAdapterConfig{Clock: clock})
+			assert.Nil(t, err)
+			t.Cleanup(func() { storageAdapter.Close() })
+
+			handler := NewRateLimiter(&RateLimiterConfig{
+				LimitByIP: &RateLimiterRateConfig{
+					Algorithm: test.algorithm,
+					Windows:   []RateLimiterWindowConfig{{MaxRequests: 2, WindowMilliseconds: 10000}},
+				},
+				StorageAdapter:    storageAdapter,
+				Clock:             clock,
+				XRateLimitHeaders: true,
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for _, expected := range test.responses {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+				assert.Equal(t, expected.status, recorder.Code)
+				assert.Equal(t, expected.retryAfter, recorder.Header().Get("Retry-After"))
+				for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+					assert.Equal(t, "2", recorder.Header().Get(prefix+"Limit"))
+					assert.Equal(t, expected.remaining, recorder.Header().Get(prefix+"Remaining"))
+					assert.Equal(t, expected.reset, recorder.Header().Get(prefix+"Reset"))
+				}
+			}
+		})
+	}
+}
+
+func TestGivenTheAliasesDisabled_WhenARequestArrives_ThenShouldOnlySendTheRateLimitHeaders(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP:      &RateLimiterRateConfig{MaxRequestsPerSecond: 5},
+		StorageAdapter: storageAdapter,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "4", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, recorder.Header().Get("X-RateLimit-Remaining"))
+	assert.Empty(t, recorder.Header().Get("Retry-After"))
+}
@@ -1,100 +1,432 @@
 package middlewares
 
 import (
-	"challenge-rate-limiter/internal/infra/storage_adapters"
 	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
 )
 
+type RateLimiterWindowConfig struct {
+	MaxRequests        int64 `json:"maxRequests"`
+	WindowMilliseconds int64 `json:"windowMilliseconds"`
+	Burst              int64 `json:"burst,omitempty"`
+}
+
 type RateLimiterRateConfig struct {
-	MaxRequestsPerSecond  int64 `json:"maxRequestsPerSecond"`
-	BlockTimeMilliseconds int64 `json:"blockTimeMilliseconds"`
+	MaxRequestsPerSecond  int64                      `json:"maxRequestsPerSecond"`
+	BlockTimeMilliseconds int64                      `json:"blockTimeMilliseconds"`
+	Algorithm             storage_adapters.Algorithm `json:"algorithm"`
+	Burst                 int64                      `json:"burst"`
+	Windows               []RateLimiterWindowConfig  `json:"windows,omitempty"`
+	// MaxConcurrentRequests limits the requests of the key being served at
+	// the same time, see RateLimiterConfig.ConcurrencyAdapter.
+	MaxConcurrentRequests int64 `json:"maxConcurrentRequests,omitempty"`
+	// BlockMultiplier escalates the blocks of repeat offenders when above one:
+	// every block started within BlockDecayMilliseconds (one day when zero) of
+	// the previous one lasts BlockMultiplier times longer, up to
+	// MaxBlockTimeMilliseconds (one hour when zero).
+	BlockMultiplier          float64 `json:"blockMultiplier,omitempty"`
+	MaxBlockTimeMilliseconds int64   `json:"maxBlockTimeMilliseconds,omitempty"`
+	BlockDecayMilliseconds   int64   `json:"blockDecayMilliseconds,omitempty"`
+}
+
+func (c *RateLimiterRateConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxRequestsPerSecond < 0 || c.BlockTimeMilliseconds < 0 || c.Burst < 0 || c.MaxConcurrentRequests < 0 ||
+		c.MaxBlockTimeMilliseconds < 0 || c.BlockDecayMilliseconds < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+	if c.BlockMultiplier != 0 && c.BlockMultiplier < 1 {
+		return fmt.Errorf("block multiplier %g is below 1", c.BlockMultiplier)
+	}
+	if _, err := storage_adapters.ParseAlgorithm(string(c.Algorithm)); err != nil {
+		return err
+	}
+	for _, window := range c.Windows {
+		if window.MaxRequests <= 0 || window.WindowMilliseconds <= 0 {
+			return fmt.Errorf("invalid window of %d requests per %dms", window.MaxRequests, window.WindowMilliseconds)
+		}
+	}
+	if c.MaxRequestsPerSecond == 0 && len(c.Windows) == 0 && c.MaxConcurrentRequests == 0 {
+		return fmt.Errorf("no limit configured")
+	}
+	return nil
+}
+
+// accessLimits returns every window enforced on the key: the per second limit
+// (when set) followed by the additional Windows.
+func (c *RateLimiterRateConfig) accessLimits() []storage_adapters.AccessLimit {
+	limits := []storage_adapters.AccessLimit{}
+	if c.MaxRequestsPerSecond > 0 {
+		limits = append(limits, storage_adapters.AccessLimit{
+			Algorithm:   c.Algorithm,
+			MaxAccesses: c.MaxRequestsPerSecond,
+			Window:      time.Second,
+			Burst:       c.Burst,
+		})
+	}
+
+	for _, window := range c.Windows {
+		limits = append(limits, storage_adapters.AccessLimit{
+			Algorithm:   c.Algorithm,
+			MaxAccesses: window.MaxRequests,
+			Window:      time.Duration(window.WindowMilliseconds) * time.Millisecond,
+			Burst:       window.Burst,
+		})
+	}
+
+	return limits
+}
+
+// ParseRateLimiterWindows parses a comma separated list of "<requests>/<duration>"
+// entries, e.g. "50/1m,1000/1h".
+func ParseRateLimiterWindows(value string) ([]RateLimiterWindowConfig, error) {
+	windows := []RateLimiterWindowConfig{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		requests, duration, found := strings.Cut(entry, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit window %q", entry)
+		}
+
+		maxRequests, err := strconv.ParseInt(strings.TrimSpace(requests), 10, 64)
+		if err != nil || maxRequests <= 0 {
+			return nil, fmt.Errorf("invalid request count in rate limit window %q", entry)
+		}
+
+		window, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || window < time.Millisecond {
+			return nil, fmt.Errorf("invalid duration in rate limit window %q", entry)
+		}
+
+		windows = append(windows, RateLimiterWindowConfig{
+			MaxRequests:        maxRequests,
+			WindowMilliseconds: window.Milliseconds(),
+		})
+	}
+
+	return windows, nil
 }
 
 type RateLimiterConfig struct {
 	LimitByIP      *RateLimiterRateConfig
 	LimitByToken   *RateLimiterRateConfig
 	StorageAdapter storage_adapters.StorageAdapter
-	CustomTokens   *map[string]*RateLimiterRateConfig
+	// TokenRegistry resolves the custom tokens managed through the admin API,
+	// falling back to the CustomTokens of the policy file.
+	TokenRegistry *TokenRegistry
+	CustomTokens  *map[string]*RateLimiterRateConfig
+	// XRateLimitHeaders also sends the X-RateLimit-* aliases of the RateLimit-* headers.
+	XRateLimitHeaders bool
+	// TokenKeyExtractor and IPKeyExtractor default to the API_KEY header and
+	// the request remote address.
+	TokenKeyExtractor KeyExtractor
+	IPKeyExtractor    KeyExtractor
+	Policies          []*RateLimiterPolicy
+	// FailureMode decides what happens to a request when the StorageAdapter
+	// fails: FailureModeClosed (the default) rejects it, FailureModeOpen lets
+	// it through and FailureModeLocal limits it with the FallbackStorageAdapter.
+	FailureMode            FailureMode
+	FallbackStorageAdapter storage_adapters.StorageAdapter
+	// Clock defaults to the SystemClock and must match the clock of the
+	// StorageAdapter.
+	Clock storage_adapters.Clock
+	// Metrics is optional, the decisions and storage calls aren't recorded
+	// when it's nil.
+	Metrics *Metrics
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// LogKeySecret keys the HMAC of the keys written to the logs. It defaults
+	// to a random secret per process, whose hashes can't be matched across
+	// replicas or restarts.
+	LogKeySecret []byte
+	// ResponseCost is optional, it charges more units to the key once the
+	// response of an allowed request is written, see also ChargeRequest.
+	ResponseCost ResponseCostFunc
+	// ConcurrencyAdapter holds the slots of the limits with
+	// MaxConcurrentRequests. It defaults to the StorageAdapter when it is also
+	// a ConcurrencyAdapter, otherwise those limits aren't enforced.
+	ConcurrencyAdapter storage_adapters.ConcurrencyAdapter
+	// ConcurrencyLeaseTTL is how long the slots of a replica that stopped
+	// outlive it, 30 seconds when zero. Slots are renewed every third of it.
+	ConcurrencyLeaseTTL time.Duration
+	// AllowList and DenyList are checked before the limits, see AccessList.
+	// The lists of the policy file and of LiveRateLimiter.SetAccessLists are
+	// added to them.
+	AllowList AccessList
+	DenyList  AccessList
+	// TrustedProxies are the proxies whose Forwarded and X-Forwarded-For
+	// headers are believed when matching the client IP against the lists.
+	TrustedProxies []*net.IPNet
+
+	allowed           *accessMatcher
+	denied            *accessMatcher
+	clientIPExtractor KeyExtractor
+}
+
+type FailureMode string
+
+const (
+	FailureModeClosed FailureMode = "closed"
+	FailureModeOpen   FailureMode = "open"
+	FailureModeLocal  FailureMode = "local"
+)
+
+func ParseFailureMode(value string) (FailureMode, error) {
+	switch FailureMode(value) {
+	case "", FailureModeClosed:
+		return FailureModeClosed, nil
+	case FailureModeOpen, FailureModeLocal:
+		return FailureMode(value), nil
+	}
+	return "", fmt.Errorf("unknown storage failure mode %q", value)
 }
 
 func NewRateLimiter(config *RateLimiterConfig) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return rateLimiter(config, next)
+	return NewLiveRateLimiter(config).Middleware
+}
+
+// Validate checks the limits, policies and lists of the config with the rules
+// of the policy file. A nil LimitByIP or LimitByToken leaves that key type
+// unlimited.
+func (c *RateLimiterConfig) Validate() error {
+	policyFile := &RateLimiterPolicyFile{
+		LimitByIP:    c.LimitByIP,
+		LimitByToken: c.LimitByToken,
+		Routes:       c.Policies,
+		Allow:        c.AllowList,
+		Deny:         c.DenyList,
 	}
+	if c.CustomTokens != nil {
+		policyFile.CustomTokens = *c.CustomTokens
+	}
+	return policyFile.validate()
 }
 
-func rateLimiter(config *RateLimiterConfig, next http.Handler) http.Handler {
+func (c *RateLimiterConfig) customToken(token string) (*RateLimiterRateConfig, bool) {
+	if c.TokenRegistry != nil {
+		if tokenConfig, ok := c.TokenRegistry.Get(token); ok {
+			return tokenConfig, true
+		}
+	}
+	if c.CustomTokens == nil {
+		return nil, false
+	}
+	tokenConfig, ok := (*c.CustomTokens)[token]
+	return tokenConfig, ok
+}
+
+func rateLimiter(limiter *LiveRateLimiter, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var block *time.Time
-		var err error
-
-		token := r.Header.Get("API_KEY")
-		if token != "" {
-			var tokenConfig *RateLimiterRateConfig
-			customTokenConfig, ok := (*config.CustomTokens)[token]
-			if ok {
-				tokenConfig = customTokenConfig
-			} else {
-				tokenConfig = config.LimitByToken
+		config := limiter.Config()
+		token := config.TokenKeyExtractor.ExtractKey(r)
+		decision, listedKeyType, listedKey := config.listed(token, func() string {
+			return config.clientIP(r)
+		})
+		if decision != "" {
+			config.observeListed(r.Context(), decision, listedKeyType, listedKey)
+			if decision == decisionDenied {
+				w.WriteHeader(403)
+				w.Write([]byte("Forbidden"))
+				return
 			}
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			block, err = checkRateLimit(r.Context(), "TOKEN", token, config, tokenConfig)
-		} else {
-			host, _, _ := net.SplitHostPort(r.RemoteAddr)
-			block, err = checkRateLimit(r.Context(), "IP", host, config, config.LimitByIP)
+		keyType, key, rateConfig, cost := config.rateLimitFor(r, token)
+
+		// the slot is taken first, so a request rejected for concurrency
+		// doesn't consume the quota of the key
+		release, acquired, err := config.acquireSlot(r.Context(), keyType, key, rateConfig)
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte("Internal Server Error"))
+			return
+		}
+		if !acquired {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+			w.Write([]byte("You have reached the maximum number of concurrent requests."))
+			return
 		}
+		defer release()
 
+		result, err := config.decide(r.Context(), keyType, key, rateConfig, cost)
 		if err != nil {
 			w.WriteHeader(500)
 			w.Write([]byte("Internal Server Error"))
 			return
 		}
 
-		if block != nil {
+		if result != nil {
+			writeRateLimitHeaders(w, config, result)
+		}
+
+		if result != nil && !result.allowed {
 			w.WriteHeader(429)
 			w.Write([]byte("You have reached the maximum number of requests or actions allowed within a certain time frame."))
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if result == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		config.serveCharged(w, r, next, result.storageAdapter, keyType, key, rateConfig)
 	})
 }
 
-func checkRateLimit(ctx context.Context, keyType string, key string, config *RateLimiterConfig, rateConfig *RateLimiterRateConfig) (*time.Time, error) {
-	if key == "" {
-		return nil, nil
+// decide checks the key applying the FailureMode, and records the decision. A
+// nil result lets the request through without limits.
+func (c *RateLimiterConfig) decide(ctx context.Context, keyType string, key string, rateConfig *RateLimiterRateConfig, cost int64) (*rateLimitResult, error) {
+	result, err := c.checkRateLimit(ctx, c.StorageAdapter, keyType, key, rateConfig, cost)
+	if err != nil {
+		c.logError(ctx, keyType, key, err)
+		switch c.FailureMode {
+		case FailureModeOpen:
+			c.Metrics.observeDecision(keyType, nil)
+			return nil, nil
+		case FailureModeLocal:
+			if c.FallbackStorageAdapter != nil {
+				result, err = c.checkRateLimit(ctx, c.FallbackStorageAdapter, keyType, key, rateConfig, cost)
+			}
+		}
 	}
 
-	block, err := config.StorageAdapter.GetBlock(ctx, keyType, key)
 	if err != nil {
 		return nil, err
 	}
 
-	if block == nil {
-		success, count, err := config.StorageAdapter.AddAccess(ctx, keyType, key, rateConfig.MaxRequestsPerSecond)
+	c.Metrics.observeDecision(keyType, result)
+	c.logDecision(ctx, keyType, key, result)
+	return result, nil
+}
+
+// rateLimitFor picks the key, the limits and the cost of the request: the
+// token when one is sent, otherwise the IP.
+func (c *RateLimiterConfig) rateLimitFor(r *http.Request, token string) (string, string, *RateLimiterRateConfig, int64) {
+	policy := c.policyFor(r)
+	keyType, key, rateConfig := c.limitFor(policy, token, func() string {
+		return c.IPKeyExtractor.ExtractKey(r)
+	})
+	return keyType, key, rateConfig, requestCost(r.Context(), policy)
+}
+
+func (c *RateLimiterConfig) limitFor(policy *RateLimiterPolicy, token string, clientKey func() string) (string, string, *RateLimiterRateConfig) {
+	if token != "" {
+		keyType := policyKeyType("TOKEN", policy)
+		if policy != nil && policy.LimitByToken != nil {
+			return keyType, token, policy.LimitByToken
+		}
+		if customTokenConfig, ok := c.customToken(token); ok {
+			return keyType, token, customTokenConfig
+		}
+		return keyType, token, c.LimitByToken
+	}
+
+	ipConfig := c.LimitByIP
+	if policy != nil && policy.LimitByIP != nil {
+		ipConfig = policy.LimitByIP
+	}
+	return policyKeyType("IP", policy), clientKey(), ipConfig
+}
+
+func policyKeyType(keyType string, policy *RateLimiterPolicy) string {
+	if policy == nil || !policy.hasLimits() {
+		return keyType
+	}
+	return keyType + ":" + policy.namespace()
+}
+
+type rateLimitResult struct {
+	// storageAdapter made the decision, the StorageAdapter or the
+	// FallbackStorageAdapter.
+	storageAdapter storage_adapters.StorageAdapter
+	allowed        bool
+	limit          int64
+	remaining      int64
+	reset          time.Duration
+	retryAfter     time.Duration
+	blockedUntil   *time.Time
+}
+
+func (c *RateLimiterConfig) checkRateLimit(ctx context.Context, storageAdapter storage_adapters.StorageAdapter, keyType string, key string, rateConfig *RateLimiterRateConfig, cost int64) (*rateLimitResult, error) {
+	// a key without limits, e.g. a token when only LimitByIP is set
+	if key == "" || rateConfig == nil {
+		return nil, nil
+	}
+
+	var result *rateLimitResult
+	admitted := []storage_adapters.AccessLimit{}
+	for _, limit := range rateConfig.accessLimits() {
+		limit.Cost = cost
+		start := time.Now()
+		success, count, block, err := storageAdapter.CheckAccess(ctx, keyType, key, limit, rateConfig.BlockTimeMilliseconds)
+		c.Metrics.observeStorage("check_access", start, err)
 		if err != nil {
+			c.refund(ctx, storageAdapter, keyType, key, admitted)
 			return nil, err
 		}
 
+		now := c.Clock.Now()
+		current := &rateLimitResult{
+			storageAdapter: storageAdapter,
+			allowed:        success,
+			limit:          limit.Quota(),
+			remaining:      max(limit.Quota()-count, 0),
+			reset:          limit.ResetAfter(count, now),
+		}
+
 		if success {
-			fmt.Printf("Access count within this window: %d\n", count)
-		} else {
-			fmt.Println("Access Denied")
-			block, err = config.StorageAdapter.AddBlock(ctx, keyType, key, rateConfig.BlockTimeMilliseconds)
-			if err != nil {
-				return nil, err
+			admitted = append(admitted, limit)
+			// the most restrictive window is the one reported in the headers
+			if result == nil || current.remaining < result.remaining {
+				result = current
 			}
+			continue
 		}
-	}
 
-	if block != nil {
-		fmt.Println("Blocked for", rateConfig.BlockTimeMilliseconds/1000, "seconds")
-		return block, nil
+		// a denied request doesn't count against the windows checked before
+		c.refund(ctx, storageAdapter, keyType, key, admitted)
+		current.remaining = 0
+		if block == nil {
+			current.retryAfter = limit.RetryAfter(now)
+			return current, nil
+		}
+
+		if count > 0 && rateConfig.escalatesBlocks() {
+			block = c.escalateBlock(ctx, storageAdapter, keyType, key, rateConfig, block)
+		}
+		current.blockedUntil = block
+		current.retryAfter = block.Sub(now)
+		current.reset = current.retryAfter
+		return current, nil
 	}
 
-	return nil, nil
+	return result, nil
+}
+
+func (c *RateLimiterConfig) refund(ctx context.Context, storageAdapter storage_adapters.StorageAdapter, keyType string, key string, limits []storage_adapters.AccessLimit) {
+	for _, limit := range limits {
+		start := time.Now()
+		err := storageAdapter.RefundAccess(ctx, keyType, key, limit)
+		c.Metrics.observeStorage("refund_access", start, err)
+		if err != nil {
+			c.logRefundError(ctx, keyType, key, err)
+		}
+	}
 }
@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStorageAdapter returns a MemoryAdapter closed when the test ends.
+func newTestStorageAdapter(t *testing.T) *storage_adapters.MemoryAdapter {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	t.Cleanup(func() { storageAdapter.Close() })
+	return storageAdapter
+}
+
+type failingStorageAdapter struct {
+	storage_adapters.StorageAdapter
+}
+
+func (a *failingStorageAdapter) CheckAccess(ctx context.Context, keyType string, key string, limit storage_adapters.AccessLimit, blockMilliseconds int64) (bool, int64, *time.Time, error) {
+	return false, 0, nil, errors.New("storage unavailable")
+}
+
+func TestGivenAFailingStorage_WhenARequestArrives_ThenShouldApplyTheFailureMode(t *testing.T) {
+	fallbackStorageAdapter := newTestStorageAdapter(t)
+
+	tests := []struct {
+		failureMode FailureMode
+		statusCodes []int
+	}{
+		{FailureModeClosed, []int{http.StatusInternalServerError, http.StatusInternalServerError}},
+		{FailureModeOpen, []int{http.StatusOK, http.StatusOK}},
+		{FailureModeLocal, []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(string(test.failureMode), func(t *testing.T) {
+			handler := NewRateLimiter(&RateLimiterConfig{
+				LimitByIP:              &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+				LimitByToken:           &RateLimiterRateConfig{MaxRequestsPerSecond: 1},
+				StorageAdapter:         &failingStorageAdapter{},
+				FailureMode:            test.failureMode,
+				FallbackStorageAdapter: fallbackStorageAdapter,
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for _, statusCode := range test.statusCodes {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+				assert.Equal(t, statusCode, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestGivenAPerSecondAndAPerMinuteWindow_WhenTheMinuteWindowDenies_ThenShouldNotConsumeThePerSecondWindow(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	handler := NewRateLimiter(&RateLimiterConfig{
+		LimitByIP: &RateLimiterRateConfig{
+			MaxRequestsPerSecond: 5,
+			Windows:              []RateLimiterWindowConfig{{MaxRequests: 3, WindowMilliseconds: 60000}},
+		},
+		StorageAdapter: storageAdapter,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, statusCode := range []int{200, 200, 200, 429, 429} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, statusCode, recorder.Code)
+	}
+
+	usage, err := storageAdapter.GetUsage(context.Background(), "IP", "192.0.2.1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []storage_adapters.Usage{
+		{Algorithm: storage_adapters.AlgorithmSlidingLog, WindowMilliseconds: 1000, Count: 3},
+		{Algorithm: storage_adapters.AlgorithmSlidingLog, WindowMilliseconds: 60000, Count: 3},
+	}, usage)
+}
@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+)
+
+const defaultTokenRegistryRefresh = 2 * time.Second
+
+var ErrTokenPolicyNotFound = errors.New("token policy not found")
+
+// ErrInvalidTokenPolicy is wrapped by the errors of Set for the tokens and
+// limits that can't be stored, as opposed to the storage errors.
+var ErrInvalidTokenPolicy = errors.New("invalid token policy")
+
+// TokenRegistry resolves the custom token limits stored in the StorageAdapter.
+// Lookups are served from a local copy that is refreshed periodically, which
+// is how changes made on one replica reach the others.
+type TokenRegistry struct {
+	storageAdapter storage_adapters.StorageAdapter
+	logger         *slog.Logger
+	logKeySecret   []byte
+	tokens         atomic.Pointer[map[string]*RateLimiterRateConfig]
+}
+
+// NewTokenRegistry logs the tokens hashed with logKeySecret, which should be
+// the LogKeySecret of the rate limiter so both hashes match.
+func NewTokenRegistry(storageAdapter storage_adapters.StorageAdapter, logger *slog.Logger, logKeySecret []byte) *TokenRegistry {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	registry := &TokenRegistry{storageAdapter: storageAdapter, logger: logger, logKeySecret: logKeySecret}
+	registry.tokens.Store(&map[string]*RateLimiterRateConfig{})
+	return registry
+}
+
+func (r *TokenRegistry) Get(token string) (*RateLimiterRateConfig, bool) {
+	tokenConfig, ok := (*r.tokens.Load())[token]
+	return tokenConfig, ok
+}
+
+func (r *TokenRegistry) List(ctx context.Context) (map[string]*RateLimiterRateConfig, error) {
+	policies, err := r.storageAdapter.ListTokenPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*RateLimiterRateConfig, len(policies))
+	for token, policy := range policies {
+		tokenConfig := &RateLimiterRateConfig{}
+		if err := json.Unmarshal(policy, tokenConfig); err != nil {
+			r.logger.Warn("Ignoring invalid token policy", "key_hash", hashKey(r.logKeySecret, token), "error", err)
+			continue
+		}
+		tokens[token] = tokenConfig
+	}
+	return tokens, nil
+}
+
+func (r *TokenRegistry) Set(ctx context.Context, token string, tokenConfig *RateLimiterRateConfig) error {
+	if token == "" {
+		return fmt.Errorf("%w: token is required", ErrInvalidTokenPolicy)
+	}
+	if tokenConfig == nil {
+		return fmt.Errorf("%w: token limits are required", ErrInvalidTokenPolicy)
+	}
+	if err := tokenConfig.validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTokenPolicy, err)
+	}
+
+	policy, err := json.Marshal(tokenConfig)
+	if err != nil {
+		return err
+	}
+
+	if err := r.storageAdapter.SetTokenPolicy(ctx, token, policy); err != nil {
+		return err
+	}
+	return r.Refresh(ctx)
+}
+
+func (r *TokenRegistry) Delete(ctx context.Context, token string) error {
+	deleted, err := r.storageAdapter.DeleteTokenPolicy(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTokenPolicyNotFound
+	}
+	return r.Refresh(ctx)
+}
+
+func (r *TokenRegistry) Refresh(ctx context.Context) error {
+	tokens, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.tokens.Store(&tokens)
+	return nil
+}
+
+// Watch refreshes the registry every interval until the returned function is
+// called. Refresh errors are logged and the last known tokens are kept.
+func (r *TokenRegistry) Watch(interval time.Duration) func() {
+	if interval <= 0 {
+		interval = defaultTokenRegistryRefresh
+	}
+
+	if err := r.Refresh(context.Background()); err != nil {
+		r.logger.Error("Error refreshing token registry", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := r.Refresh(context.Background()); err != nil {
+					r.logger.Error("Error refreshing token registry", "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
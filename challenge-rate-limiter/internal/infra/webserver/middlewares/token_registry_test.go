@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenTwoReplicas_WhenATokenPolicyIsSet_ThenTheOtherReplicaShouldSeeItAfterRefresh(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+	ctx := context.Background()
+	replicaA := NewTokenRegistry(storageAdapter, nil, nil)
+	replicaB := NewTokenRegistry(storageAdapter, nil, nil)
+
+	assert.Nil(t, replicaA.Set(ctx, "GOLD", &RateLimiterRateConfig{MaxRequestsPerSecond: 100}))
+	tokenConfig, ok := replicaA.Get("GOLD")
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), tokenConfig.MaxRequestsPerSecond)
+
+	_, ok = replicaB.Get("GOLD")
+	assert.False(t, ok)
+	assert.Nil(t, replicaB.Refresh(ctx))
+	_, ok = replicaB.Get("GOLD")
+	assert.True(t, ok)
+
+	assert.Nil(t, replicaB.Delete(ctx, "GOLD"))
+	assert.ErrorIs(t, replicaB.Delete(ctx, "GOLD"), ErrTokenPolicyNotFound)
+	assert.Nil(t, replicaA.Refresh(ctx))
+	_, ok = replicaA.Get("GOLD")
+	assert.False(t, ok)
+}
+
+func TestGivenAnInvalidTokenPolicy_WhenSettingIt_ThenShouldReceiveAnError(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	registry := NewTokenRegistry(storageAdapter, nil, nil)
+	assert.ErrorIs(t, registry.Set(context.Background(), "GOLD", &RateLimiterRateConfig{Algorithm: "leaky"}), ErrInvalidTokenPolicy)
+	assert.ErrorIs(t, registry.Set(context.Background(), "", &RateLimiterRateConfig{MaxRequestsPerSecond: 100}), ErrInvalidTokenPolicy)
+}
+
+func TestGivenARegisteredToken_WhenResolvingCustomTokens_ThenTheRegistryShouldWinOverThePolicyFile(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+
+	registry := NewTokenRegistry(storageAdapter, nil, nil)
+	assert.Nil(t, registry.Set(context.Background(), "ABC", &RateLimiterRateConfig{MaxRequestsPerSecond: 100}))
+
+	customTokens := map[string]*RateLimiterRateConfig{"ABC": {MaxRequestsPerSecond: 20}, "DEF": {MaxRequestsPerSecond: 30}}
+	config := &RateLimiterConfig{TokenRegistry: registry, CustomTokens: &customTokens}
+
+	tokenConfig, _ := config.customToken("ABC")
+	assert.Equal(t, int64(100), tokenConfig.MaxRequestsPerSecond)
+	tokenConfig, _ = config.customToken("DEF")
+	assert.Equal(t, int64(30), tokenConfig.MaxRequestsPerSecond)
+}
+
+func TestGivenALogKeySecret_WhenAnInvalidTokenPolicyIsListed_ThenShouldLogTheTokenHashedWithTheSecret(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+	assert.Nil(t, storageAdapter.SetTokenPolicy(context.Background(), "GOLD", []byte("{")))
+	output := &bytes.Buffer{}
+	secret := []byte("log-key-secret")
+
+	registry := NewTokenRegistry(storageAdapter, slog.New(slog.NewJSONHandler(output, nil)), secret)
+	assert.Nil(t, registry.Refresh(context.Background()))
+
+	record := map[string]any{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &record))
+	assert.Equal(t, "Ignoring invalid token policy", record["msg"])
+	assert.Equal(t, hashKey(secret, "GOLD"), record["key_hash"])
+}
@@ -2,51 +2,142 @@ package webserver
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
 )
 
 type Route struct {
-	Path    string
-	Handler http.HandlerFunc
-	Method  string
+	Path        string
+	Handler     http.HandlerFunc
+	Method      string
+	Middlewares []func(next http.Handler) http.Handler
+	// Unlimited routes are served before the middlewares installed with Use.
+	Unlimited bool
 }
 
 type WebServer struct {
 	Router        chi.Router
 	Handlers      map[string]Route
 	WebServerPort string
+	RateLimiter   *middlewares.LiveRateLimiter
+	Logger        *slog.Logger
+	middlewares   []func(next http.Handler) http.Handler
 }
 
-func NewWebServer(serverPort string) *WebServer {
+func NewWebServer(serverPort string, logger *slog.Logger) *WebServer {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &WebServer{
 		Router:        chi.NewRouter(),
 		Handlers:      make(map[string]Route),
 		WebServerPort: serverPort,
+		Logger:        logger,
+	}
+}
+
+func (s *WebServer) AddHandler(path string, handler http.HandlerFunc, method string, routeMiddlewares ...func(next http.Handler) http.Handler) {
+	var key = fmt.Sprintf("%s-%s", method, path)
+	s.Handlers[key] = Route{
+		Path:        path,
+		Handler:     handler,
+		Method:      method,
+		Middlewares: routeMiddlewares,
 	}
 }
 
-func (s *WebServer) AddHandler(path string, handler http.HandlerFunc, method string) {
+// AddUnlimitedHandler registers a handler that the rate limiter and the other
+// middlewares installed with Use don't see, such as the metrics endpoint.
+func (s *WebServer) AddUnlimitedHandler(path string, handler http.HandlerFunc, method string) {
 	var key = fmt.Sprintf("%s-%s", method, path)
 	s.Handlers[key] = Route{
-		Path:    path,
-		Handler: handler,
-		Method:  method,
+		Path:      path,
+		Handler:   handler,
+		Method:    method,
+		Unlimited: true,
 	}
 }
 
+// AddLimitedHandler registers a handler with its own rate limit policy. The
+// rate limiter must be installed with UseRateLimiter beforehand.
+func (s *WebServer) AddLimitedHandler(path string, handler http.HandlerFunc, method string, policy *middlewares.RateLimiterPolicy) {
+	policy.Method = method
+	policy.Pattern = path
+	s.RateLimiter.AddPolicy(policy)
+	s.AddHandler(path, handler, method)
+}
+
+func (s *WebServer) UseRateLimiter(rateLimiter *middlewares.LiveRateLimiter) {
+	s.RateLimiter = rateLimiter
+	s.Use(rateLimiter.Middleware)
+}
+
+// Use adds a middleware to the routes that aren't unlimited. Middlewares run
+// in the order they are added, after the request logger.
 func (s *WebServer) Use(middleware func(next http.Handler) http.Handler) {
-	s.Router.Use(middleware)
+	s.middlewares = append(s.middlewares, middleware)
 }
 
 func (s *WebServer) Start() {
-	s.Router.Use(middleware.Logger)
-	s.Use(middleware.Recoverer)
+	s.Logger.Info("Starting web server", "port", s.WebServerPort)
+	if err := http.ListenAndServe(":"+s.WebServerPort, s.handler()); err != nil {
+		s.Logger.Error("Web server stopped", "error", err)
+	}
+}
+
+// handler registers the routes on s.Router. Every route shares the router, so
+// the middlewares resolve the chi route pattern of the request, and the
+// unlimited ones are served before the middlewares added with Use run.
+func (s *WebServer) handler() http.Handler {
+	unlimited := chi.NewRouter()
+	s.Router.Use(s.requestLogger, middleware.Recoverer, serveUnlimited(unlimited))
+	s.Router.Use(s.middlewares...)
 	for _, handler := range s.Handlers {
-		s.Router.MethodFunc(handler.Method, handler.Path, handler.Handler)
-		fmt.Println("Registering handler", handler.Path, handler.Method)
+		router := s.Router
+		if handler.Unlimited {
+			router = unlimited
+		}
+		router.With(handler.Middlewares...).MethodFunc(handler.Method, handler.Path, handler.Handler)
+		s.Logger.Debug("Registering handler", "path", handler.Path, "method", handler.Method, "unlimited", handler.Unlimited)
 	}
-	http.ListenAndServe(":"+s.WebServerPort, s.Router)
+	return s.Router
+}
+
+// serveUnlimited hands the requests matching a route of unlimited to it,
+// skipping the next middlewares.
+func serveUnlimited(unlimited *chi.Mux) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.RawPath
+			if path == "" {
+				path = r.URL.Path
+			}
+			if unlimited.Match(chi.NewRouteContext(), r.Method, path) {
+				unlimited.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *WebServer) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		s.Logger.LogAttrs(r.Context(), slog.LevelInfo, "Request served",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", ww.Status()),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
 }
@@ -0,0 +1,87 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGivenAnUnlimitedHandler_WhenTheMiddlewaresRejectEveryRequest_ThenShouldStillServeIt(t *testing.T) {
+	webserver := NewWebServer("0", nil)
+	webserver.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+	})
+	webserver.AddHandler("/", func(w http.ResponseWriter, r *http.Request) {}, "GET")
+	webserver.AddUnlimitedHandler("/metrics", func(w http.ResponseWriter, r *http.Request) {}, "GET")
+	handler := webserver.handler()
+
+	for path, status := range map[string]int{"/": http.StatusTooManyRequests, "/metrics": http.StatusOK, "/unknown": http.StatusTooManyRequests} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, status, recorder.Code, path)
+	}
+}
+
+func newRateLimitedTestWebServer(t *testing.T) *WebServer {
+	storageAdapter, err := storage_adapters.InitMemoryAdapter()
+	assert.Nil(t, err)
+	t.Cleanup(func() { storageAdapter.Close() })
+
+	webserver := NewWebServer("0", nil)
+	webserver.UseRateLimiter(middlewares.NewLiveRateLimiter(&middlewares.RateLimiterConfig{
+		LimitByIP:      &middlewares.RateLimiterRateConfig{MaxRequestsPerSecond: 100},
+		StorageAdapter: storageAdapter,
+	}))
+	return webserver
+}
+
+func sendWebServerTestRequest(handler http.Handler, method string, path string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder.Code
+}
+
+func TestGivenALimitedHandler_WhenServedByTheWebServer_ThenShouldApplyItsPolicyBehindTheUnlimitedRoutes(t *testing.T) {
+	webserver := newRateLimitedTestWebServer(t)
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	webserver.AddHandler("/", handler, "GET")
+	webserver.AddUnlimitedHandler("/metrics", handler, "GET")
+	webserver.AddLimitedHandler("/login", handler, "POST", &middlewares.RateLimiterPolicy{
+		LimitByIP: &middlewares.RateLimiterRateConfig{Windows: []middlewares.RateLimiterWindowConfig{{MaxRequests: 2, WindowMilliseconds: 60000}}},
+	})
+	server := webserver.handler()
+
+	assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "POST", "/login"))
+	assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "POST", "/login"))
+	assert.Equal(t, http.StatusTooManyRequests, sendWebServerTestRequest(server, "POST", "/login"))
+
+	assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "GET", "/"))
+	for i := 0; i < 200; i++ {
+		assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "GET", "/metrics"))
+	}
+}
+
+func TestGivenALimitedHandlerWithARouteParameter_WhenServedByTheWebServer_ThenShouldShareItsQuotaAcrossThePathsOfTheRoute(t *testing.T) {
+	webserver := newRateLimitedTestWebServer(t)
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	webserver.AddHandler("/users/{id}", handler, "DELETE")
+	webserver.AddLimitedHandler("/users/{id}", handler, "GET", &middlewares.RateLimiterPolicy{
+		LimitByIP: &middlewares.RateLimiterRateConfig{Windows: []middlewares.RateLimiterWindowConfig{{MaxRequests: 2, WindowMilliseconds: 60000}}},
+	})
+	server := webserver.handler()
+
+	assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "GET", "/users/1"))
+	assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "GET", "/users/2"))
+	assert.Equal(t, http.StatusTooManyRequests, sendWebServerTestRequest(server, "GET", "/users/3"))
+
+	// the policy is bound to GET, other methods of the route keep the global limit
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, sendWebServerTestRequest(server, "DELETE", "/users/1"))
+	}
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// APIKeyMetadata is the gRPC metadata key of the token.
+const APIKeyMetadata = "api_key"
+
+// UnaryServerInterceptor rate limits unary calls with the limits, policies and
+// Store of the limiter. The caller is identified by the api_key metadata or,
+// without it, by the peer IP. Policies apply when their Pattern is the full
+// method name (e.g. "/pb.OrderService/CreateOrder") and they have no Method.
+// A caller with a Limit.MaxConcurrentRequests holds one of its slots until the
+// handler returns. Limited calls fail with codes.ResourceExhausted and a
+// RetryInfo detail, and the callers in the deny list with
+// codes.PermissionDenied.
+func UnaryServerInterceptor(limiter *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		decision, release, err := checkCall(ctx, limiter, info.FullMethod)
+		defer release()
+		if decision != nil {
+			grpc.SetHeader(ctx, rateLimitMetadata(decision))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams. A stream
+// counts as a single call when it is opened, the messages aren't limited, and
+// holds its concurrency slot until it is closed.
+func StreamServerInterceptor(limiter *Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		decision, release, err := checkCall(ss.Context(), limiter, info.FullMethod)
+		defer release()
+		if decision != nil {
+			ss.SetHeader(rateLimitMetadata(decision))
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkCall(ctx context.Context, limiter *Limiter, fullMethod string) (*Decision, func(), error) {
+	decision, release, err := limiter.Acquire(ctx, fullMethod, callToken(ctx), peerHost(ctx))
+	if errors.Is(err, ErrDenied) {
+		return nil, release, status.Error(codes.PermissionDenied, "Forbidden")
+	}
+	if err != nil {
+		return nil, release, status.Error(codes.Internal, "Internal Server Error")
+	}
+	if decision == nil || decision.Allowed {
+		return decision, release, nil
+	}
+
+	message := "You have reached the maximum number of requests or actions allowed within a certain time frame."
+	if decision.Concurrent {
+		message = "You have reached the maximum number of concurrent requests."
+	}
+	st, err := status.New(codes.ResourceExhausted, message).
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(decision.RetryAfter)})
+	if err != nil {
+		return decision, release, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return decision, release, st.Err()
+}
+
+func callToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(APIKeyMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// rateLimitMetadata mirrors the RateLimit-* HTTP headers. Like the HTTP
+// responses, a call without a free concurrency slot only gets retry-after.
+func rateLimitMetadata(decision *Decision) metadata.MD {
+	if decision.Concurrent {
+		return metadata.Pairs("retry-after", strconv.FormatInt(max(deltaSeconds(decision.RetryAfter), 1), 10))
+	}
+	md := metadata.Pairs(
+		"ratelimit-limit", strconv.FormatInt(decision.Limit, 10),
+		"ratelimit-remaining", strconv.FormatInt(decision.Remaining, 10),
+		"ratelimit-reset", strconv.FormatInt(deltaSeconds(decision.Reset), 10),
+	)
+	if !decision.Allowed {
+		md.Set("retry-after", strconv.FormatInt(max(deltaSeconds(decision.RetryAfter), 1), 10))
+	}
+	return md
+}
+
+func deltaSeconds(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50051}})
+}
+
+func TestGivenTheUnaryInterceptor_WhenTheCallerIsBlocked_ThenShouldFailWithResourceExhaustedAndRetryInfo(t *testing.T) {
+	limiter := newTestLimiter(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 10000}),
+		WithTokenLimit(Limit{MaxRequestsPerSecond: 100}),
+	)
+	interceptor := UnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.OrderService/CreateOrder"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	response, err := interceptor(peerContext("10.0.0.1"), nil, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", response)
+
+	_, err = interceptor(peerContext("10.0.0.1"), nil, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Len(t, st.Details(), 1)
+	retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+	assert.True(t, ok)
+	assert.InDelta(t, 10*time.Second, retryInfo.RetryDelay.AsDuration(), float64(time.Second))
+
+	// another peer and a token have their own quota
+	_, err = interceptor(peerContext("10.0.0.2"), nil, info, handler)
+	assert.Nil(t, err)
+	ctx := metadata.NewIncomingContext(peerContext("10.0.0.1"), metadata.Pairs(APIKeyMetadata, "ABC"))
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+}
+
+func TestGivenAPolicyForAMethod_WhenStreamsAreOpened_ThenShouldLimitTheMethod(t *testing.T) {
+	limiter := newTestLimiter(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 100}),
+		WithPolicies(&Policy{Pattern: "/pb.OrderService/WatchOrders", LimitByIP: &Limit{MaxRequestsPerSecond: 1}}),
+	)
+	interceptor := StreamServerInterceptor(limiter)
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+
+	watch := &grpc.StreamServerInfo{FullMethod: "/pb.OrderService/WatchOrders"}
+	stream := &testServerStream{ctx: peerContext("10.0.0.1")}
+	assert.Nil(t, interceptor(nil, stream, watch, handler))
+	assert.Equal(t, []string{"0"}, stream.header.Get("ratelimit-remaining"))
+
+	stream = &testServerStream{ctx: peerContext("10.0.0.1")}
+	assert.Equal(t, codes.ResourceExhausted, status.Code(interceptor(nil, stream, watch, handler)))
+	assert.Equal(t, []string{"1"}, stream.header.Get("retry-after"))
+
+	other := &grpc.StreamServerInfo{FullMethod: "/pb.OrderService/ListOrders"}
+	assert.Nil(t, interceptor(nil, &testServerStream{ctx: peerContext("10.0.0.1")}, other, handler))
+}
+
+func TestGivenADeniedPeer_WhenCallingTheUnaryInterceptor_ThenShouldFailWithPermissionDenied(t *testing.T) {
+	limiter := newTestLimiter(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 10000}),
+		WithDenyList(AccessList{IPs: []string{"10.0.0.0/24"}}),
+	)
+	interceptor := UnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.OrderService/CreateOrder"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	_, err := interceptor(peerContext("10.0.0.1"), nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = interceptor(peerContext("10.0.1.1"), nil, info, handler)
+	assert.Nil(t, err)
+}
+
+func TestGivenAConcurrencyLimit_WhenAStreamIsOpen_ThenShouldLimitTheOtherStreamsUntilItCloses(t *testing.T) {
+	limiter := newTestLimiter(t, newTestStore(t), WithIPLimit(Limit{MaxRequestsPerSecond: 100, MaxConcurrentRequests: 1}))
+	interceptor := StreamServerInterceptor(limiter)
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.OrderService/WatchOrders"}
+
+	opened, closed := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- interceptor(nil, &testServerStream{ctx: peerContext("10.0.0.1")}, info, func(srv interface{}, stream grpc.ServerStream) error {
+			close(opened)
+			<-closed
+			return nil
+		})
+	}()
+	<-opened
+
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	stream := &testServerStream{ctx: peerContext("10.0.0.1")}
+	err := interceptor(nil, stream, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, stream.header.Get("retry-after"))
+
+	close(closed)
+	assert.Nil(t, <-done)
+	assert.Nil(t, interceptor(nil, &testServerStream{ctx: peerContext("10.0.0.1")}, info, handler))
+}
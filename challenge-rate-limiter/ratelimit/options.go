@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+)
+
+// Option configures the limiter built by New or NewLimiter, see the With*
+// functions.
+type Option struct {
+	apply func(config *middlewares.RateLimiterConfig)
+}
+
+// WithIPLimit limits the requests that don't send a token, by client IP
+// unless WithKeyFunc says otherwise.
+func WithIPLimit(limit Limit) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.LimitByIP = &limit
+	}}
+}
+
+// WithTokenLimit limits the requests that send a token.
+func WithTokenLimit(limit Limit) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.LimitByToken = &limit
+	}}
+}
+
+// WithKeyFunc replaces the client IP as the key of the requests without a
+// token. An empty key skips the rate limiter.
+func WithKeyFunc(keyFunc func(r *http.Request) string) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.IPKeyExtractor = middlewares.KeyExtractorFunc(keyFunc)
+	}}
+}
+
+// WithTokenKeyFunc replaces the API_KEY header as the token of the request.
+func WithTokenKeyFunc(keyFunc func(r *http.Request) string) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.TokenKeyExtractor = middlewares.KeyExtractorFunc(keyFunc)
+	}}
+}
+
+// WithCustomTokens gives some tokens their own limits.
+func WithCustomTokens(tokens map[string]*Limit) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.CustomTokens = &tokens
+	}}
+}
+
+// WithTokenRegistry resolves the custom tokens from the Store before the ones
+// of WithCustomTokens.
+func WithTokenRegistry(registry *TokenRegistry) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.TokenRegistry = registry
+	}}
+}
+
+// WithPolicies adds route policies. They match chi route patterns.
+func WithPolicies(policies ...*Policy) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.Policies = append(config.Policies, policies...)
+	}}
+}
+
+// WithFailureMode decides what happens when the Store fails. The fallback is
+// only used by FailureModeLocal.
+func WithFailureMode(mode FailureMode, fallback Store) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.FailureMode = mode
+		config.FallbackStorageAdapter = fallback
+	}}
+}
+
+// WithXRateLimitHeaders also sends the X-RateLimit-* aliases of the
+// RateLimit-* headers when enabled.
+func WithXRateLimitHeaders(enabled bool) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.XRateLimitHeaders = enabled
+	}}
+}
+
+// WithClock must be the same Clock given to the Store.
+func WithClock(clock Clock) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.Clock = clock
+	}}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.Logger = logger
+	}}
+}
+
+// WithLogKeySecret keys the HMAC of the keys written to the logs, so the
+// hashes of every replica match. Each process draws a random secret otherwise.
+func WithLogKeySecret(secret []byte) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.LogKeySecret = secret
+	}}
+}
+
+func WithMetrics(metrics *Metrics) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.Metrics = metrics
+	}}
+}
+
+// WithConcurrencyStore holds the slots of the limits with
+// MaxConcurrentRequests in store, when it isn't the Store of the limiter (e.g.
+// a CircuitBreakerStore, whose WrapConcurrency puts the ConcurrencyStore in the
+// same circuit). A slot whose replica stops is freed
+// after leaseTTL, 30 seconds when zero.
+func WithConcurrencyStore(store ConcurrencyStore, leaseTTL time.Duration) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.ConcurrencyAdapter = store
+		config.ConcurrencyLeaseTTL = leaseTTL
+	}}
+}
+
+// WithResponseCost charges the units returned by costFunc to the caller once
+// the response is written, e.g. by response size or latency. Units are only
+// added, a request is never refunded.
+func WithResponseCost(costFunc func(r *http.Request, response ResponseInfo) int64) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.ResponseCost = costFunc
+	}}
+}
+
+// WithAllowList lets the IPs, CIDR ranges and tokens of list skip the limits.
+// Use Limiter.SetAccessLists to change the lists at runtime.
+func WithAllowList(list AccessList) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.AllowList = list
+	}}
+}
+
+// WithDenyList rejects the IPs, CIDR ranges and tokens of list with 403, even
+// when they are also in the allow list.
+func WithDenyList(list AccessList) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.DenyList = list
+	}}
+}
+
+// WithTrustedProxies believes the Forwarded and X-Forwarded-For headers of the
+// requests from proxies when matching the client IP against the allow and deny
+// lists, see ParseTrustedProxies.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return Option{func(config *middlewares.RateLimiterConfig) {
+		config.TrustedProxies = proxies
+	}}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+)
+
+func ParseAlgorithm(value string) (Algorithm, error) {
+	return storage_adapters.ParseAlgorithm(value)
+}
+
+// ParseWindows parses a comma separated list of "<requests>/<duration>"
+// entries, e.g. "50/1m,1000/1h".
+func ParseWindows(value string) ([]Window, error) {
+	return middlewares.ParseRateLimiterWindows(value)
+}
+
+func ParseFailureMode(value string) (FailureMode, error) {
+	return middlewares.ParseFailureMode(value)
+}
+
+// ParseAccessList reads comma separated lists of IPs and CIDR ranges and of
+// tokens, for WithAllowList and WithDenyList.
+func ParseAccessList(ips string, tokens string) (AccessList, error) {
+	return middlewares.ParseAccessList(ips, tokens)
+}
+
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	return middlewares.ParseTrustedProxies(value)
+}
+
+// ParseKeyFunc parses the LIMIT_BY_*_KEY syntax, a comma separated list of
+// "ip", "forwarded", "route", "header:<name>" and "jwt:<claim>" sources, for
+// WithKeyFunc and WithTokenKeyFunc.
+func ParseKeyFunc(value string, trustedProxies []*net.IPNet, jwtSecret []byte) (func(r *http.Request) string, error) {
+	extractor, err := middlewares.ParseKeyExtractor(value, trustedProxies, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	return extractor.ExtractKey, nil
+}
@@ -0,0 +1,126 @@
+// Package ratelimit is the importable API of the rate limiter. New returns a
+// plain func(http.Handler) http.Handler middleware, so it works with chi,
+// gorilla/mux or a net/http ServeMux:
+//
+//	store, _ := ratelimit.NewRedisStore(ratelimit.RedisConfig{Addresses: []string{"localhost:6379"}})
+//	limit, err := ratelimit.New(store,
+//		ratelimit.WithIPLimit(ratelimit.Limit{MaxRequestsPerSecond: 5, BlockTimeMilliseconds: 10000}),
+//		ratelimit.WithTokenLimit(ratelimit.Limit{MaxRequestsPerSecond: 10, BlockTimeMilliseconds: 5000}),
+//		ratelimit.WithKeyFunc(func(r *http.Request) string { return r.Header.Get("X-Client-ID") }),
+//	)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8080", limit(mux))
+//
+// Route policies match chi route patterns, so they only apply behind a chi
+// router. UnaryServerInterceptor and StreamServerInterceptor apply the same
+// limits to gRPC servers.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+)
+
+type (
+	// Store keeps the access counters and blocks, see the New*Store functions.
+	Store     = storage_adapters.StorageAdapter
+	Algorithm = storage_adapters.Algorithm
+	Clock     = storage_adapters.Clock
+	FakeClock = storage_adapters.FakeClock
+
+	// ConcurrencyStore holds the slots of Limit.MaxConcurrentRequests. The
+	// memory and Redis stores are also ConcurrencyStores.
+	ConcurrencyStore = storage_adapters.ConcurrencyAdapter
+
+	// Limit is the limit of one identity: MaxRequestsPerSecond and/or
+	// additional Windows, blocking the identity for BlockTimeMilliseconds once
+	// exceeded.
+	Limit         = middlewares.RateLimiterRateConfig
+	Window        = middlewares.RateLimiterWindowConfig
+	Policy        = middlewares.RateLimiterPolicy
+	PolicyFile    = middlewares.RateLimiterPolicyFile
+	FailureMode   = middlewares.FailureMode
+	KeyExtractor  = middlewares.KeyExtractor
+	Metrics       = middlewares.Metrics
+	TokenRegistry = middlewares.TokenRegistry
+	ResponseInfo  = middlewares.ResponseInfo
+	AccessList    = middlewares.AccessList
+
+	// Limiter is the middleware whose limits can be changed at runtime with
+	// AddPolicy and ApplyPolicyFile.
+	Limiter  = middlewares.LiveRateLimiter
+	Decision = middlewares.Decision
+)
+
+const (
+	AlgorithmSlidingLog  = storage_adapters.AlgorithmSlidingLog
+	AlgorithmFixedWindow = storage_adapters.AlgorithmFixedWindow
+	AlgorithmTokenBucket = storage_adapters.AlgorithmTokenBucket
+	AlgorithmGCRA        = storage_adapters.AlgorithmGCRA
+
+	FailureModeClosed = middlewares.FailureModeClosed
+	FailureModeOpen   = middlewares.FailureModeOpen
+	FailureModeLocal  = middlewares.FailureModeLocal
+)
+
+var SystemClock = storage_adapters.SystemClock
+
+// ErrDenied is returned by Limiter.Check and Limiter.Acquire for the callers in
+// the deny list.
+var ErrDenied = middlewares.ErrDenied
+
+// New returns the rate limiter middleware, or an error when the limits are
+// invalid, e.g. a Limit without any request, window or concurrency limit.
+func New(store Store, options ...Option) (func(next http.Handler) http.Handler, error) {
+	limiter, err := NewLimiter(store, options...)
+	if err != nil {
+		return nil, err
+	}
+	return limiter.Middleware, nil
+}
+
+// NewLimiter is New for callers that change the limits at runtime. An identity
+// whose limit isn't set, e.g. a token when only WithIPLimit is given, is not
+// limited.
+func NewLimiter(store Store, options ...Option) (*Limiter, error) {
+	config := &middlewares.RateLimiterConfig{StorageAdapter: store}
+	for _, option := range options {
+		// the zero Option does nothing
+		if option.apply == nil {
+			continue
+		}
+		option.apply(config)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rate limiter config: %w", err)
+	}
+	return middlewares.NewLiveRateLimiter(config), nil
+}
+
+// WatchPolicyFile applies the policy file to the limiter whenever it changes
+// until the returned function is called.
+func WatchPolicyFile(path string, limiter *Limiter) (func() error, error) {
+	return middlewares.WatchPolicyFile(path, limiter)
+}
+
+func LoadPolicyFile(path string) (*PolicyFile, error) {
+	return middlewares.LoadRateLimiterPolicyFile(path)
+}
+
+// ContextWithCost sets how many requests the request counts as, replacing the
+// Cost of its policy. Middlewares running before the limiter call it.
+func ContextWithCost(ctx context.Context, cost int64) context.Context {
+	return middlewares.WithRequestCost(ctx, cost)
+}
+
+// Charge lets a handler charge more units to the caller once it returns, e.g.
+// when the cost of a query is only known after running it.
+func Charge(ctx context.Context, units int64) {
+	middlewares.ChargeRequest(ctx, units)
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(t *testing.T) *MemoryStore {
+	store, err := NewMemoryStore(MemoryStoreConfig{})
+	assert.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newTestMiddleware(t *testing.T, store Store, options ...Option) func(next http.Handler) http.Handler {
+	middleware, err := New(store, options...)
+	assert.Nil(t, err)
+	return middleware
+}
+
+func newTestLimiter(t *testing.T, store Store, options ...Option) *Limiter {
+	limiter, err := NewLimiter(store, options...)
+	assert.Nil(t, err)
+	return limiter
+}
+
+func serve(handler http.Handler, method string, path string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGivenAServeMux_WhenTheIPLimitIsExceeded_ThenShouldRejectTheRequest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := newTestMiddleware(t, newTestStore(t), WithIPLimit(Limit{MaxRequestsPerSecond: 2}))(mux)
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+	recorder := serve(handler, "GET", "/", nil)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+}
+
+func TestGivenAKeyFunc_WhenRequestsComeFromDifferentClients_ThenEachShouldHaveItsOwnQuota(t *testing.T) {
+	handler := newTestMiddleware(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 1}),
+		WithKeyFunc(func(r *http.Request) string { return r.Header.Get("X-Client-ID") }),
+		WithXRateLimitHeaders(true),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	first := http.Header{"X-Client-Id": {"first"}}
+	second := http.Header{"X-Client-Id": {"second"}}
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", first).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/", first).Code)
+	recorder := serve(handler, "GET", "/", second)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "0", recorder.Header().Get("X-RateLimit-Remaining"))
+
+	// requests without a key aren't limited
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+}
+
+func TestGivenTokenOptions_WhenATokenIsSent_ThenShouldUseTheTokenLimits(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store, err := NewMemoryStore(MemoryStoreConfig{Clock: clock})
+	assert.Nil(t, err)
+	defer store.Close()
+
+	handler := newTestMiddleware(t, store,
+		WithIPLimit(Limit{MaxRequestsPerSecond: 1}),
+		WithTokenLimit(Limit{MaxRequestsPerSecond: 1, BlockTimeMilliseconds: 5000}),
+		WithTokenKeyFunc(func(r *http.Request) string { return r.URL.Query().Get("token") }),
+		WithCustomTokens(map[string]*Limit{"VIP": {MaxRequestsPerSecond: 3}}),
+		WithClock(clock),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/?token=ABC", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/?token=ABC", nil).Code)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(handler, "GET", "/?token=VIP", nil).Code)
+	}
+
+	clock.Advance(4 * time.Second)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/?token=ABC", nil).Code)
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/?token=ABC", nil).Code)
+}
+
+func TestGivenAChiRouter_WhenARoutePolicyIsSet_ThenShouldLimitTheRouteSeparately(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(newTestMiddleware(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 100}),
+		WithPolicies(&Policy{Method: "POST", Pattern: "/login", LimitByIP: &Limit{MaxRequestsPerSecond: 1}}),
+	))
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.Post("/login", func(w http.ResponseWriter, r *http.Request) {})
+
+	assert.Equal(t, http.StatusOK, serve(router, "POST", "/login", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "POST", "/login", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(router, "GET", "/", nil).Code)
+}
+
+func TestGivenAResponseCost_WhenAServeMuxHandlerCharges_ThenShouldAddBothCharges(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		Charge(r.Context(), 2)
+		w.Write(make([]byte, 2048))
+	})
+	handler := newTestMiddleware(t, newTestStore(t),
+		WithIPLimit(Limit{Windows: []Window{{MaxRequests: 10, WindowMilliseconds: 60000}}}),
+		WithResponseCost(func(r *http.Request, response ResponseInfo) int64 { return response.Bytes / 1024 }),
+	)(mux)
+
+	assert.Equal(t, "9", serve(handler, "GET", "/", nil).Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "4", serve(handler, "GET", "/", nil).Header().Get("RateLimit-Remaining"))
+}
+
+func TestGivenOnlyAnIPLimit_WhenATokenIsSent_ThenShouldNotLimitTheToken(t *testing.T) {
+	handler := newTestMiddleware(t, newTestStore(t),
+		WithIPLimit(Limit{MaxRequestsPerSecond: 1}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	token := http.Header{"Api_key": {"ABC"}}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", token).Code)
+	}
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/", nil).Code)
+}
+
+func TestGivenALimitWithoutAnyLimit_WhenCreatingTheLimiter_ThenShouldFail(t *testing.T) {
+	_, err := NewLimiter(newTestStore(t), WithIPLimit(Limit{BlockTimeMilliseconds: 1000}))
+	assert.ErrorContains(t, err, "limitByIP: no limit configured")
+
+	_, err = New(newTestStore(t), WithTokenLimit(Limit{MaxRequestsPerSecond: -1}))
+	assert.ErrorContains(t, err, "limitByToken: limits can't be negative")
+}
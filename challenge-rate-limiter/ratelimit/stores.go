@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/storage_adapters"
+	"github.com/mbombonato/pos-go-expert/challenge-rate-limiter/internal/infra/webserver/middlewares"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	MemoryStoreConfig = storage_adapters.MemoryAdapterConfig
+	MemoryStore       = storage_adapters.MemoryAdapter
+	RedisConfig       = storage_adapters.RedisConfig
+	RedisStore        = storage_adapters.RedisAdapter
+	AggregatingStore  = storage_adapters.AggregatingAdapter
+	PostgresStore     = storage_adapters.PostgresAdapter
+	MemcachedStore    = storage_adapters.MemcachedAdapter
+	BoltStore         = storage_adapters.BoltAdapter
+	// CircuitBreakerStore also wraps the ConcurrencyStore of the limiter
+	// through WrapConcurrency.
+	CircuitBreakerStore = storage_adapters.CircuitBreakerAdapter
+)
+
+var ErrCircuitOpen = storage_adapters.ErrCircuitOpen
+
+// NewMemoryStore keeps the counters in the process, so every replica limits
+// on its own. Close stops its janitor.
+func NewMemoryStore(config MemoryStoreConfig) (*MemoryStore, error) {
+	return storage_adapters.InitMemoryAdapterWithConfig(config)
+}
+
+func NewRedisStore(config RedisConfig) (*RedisStore, error) {
+	return storage_adapters.InitRedisAdapterWithConfig(config)
+}
+
+// NewRedisStoreFromClient shares a client the service already has.
+func NewRedisStoreFromClient(client redis.UniversalClient, clock Clock, logger *slog.Logger) *RedisStore {
+	return storage_adapters.NewRedisAdapter(client, clock, logger)
+}
+
+// NewAggregatingStore counts locally and syncs the counts to Redis every
+// syncInterval, trading some overshoot for fewer Redis calls.
+func NewAggregatingStore(redisStore *RedisStore, syncInterval time.Duration) *AggregatingStore {
+	return storage_adapters.NewAggregatingAdapter(redisStore, syncInterval)
+}
+
+func NewPostgresStore(dsn string, clock Clock, logger *slog.Logger) (*PostgresStore, error) {
+	return storage_adapters.InitPostgresAdapter(dsn, clock, logger)
+}
+
+func NewMemcachedStore(addresses []string, clock Clock, logger *slog.Logger) (*MemcachedStore, error) {
+	return storage_adapters.InitMemcachedAdapter(addresses, clock, logger)
+}
+
+func NewBoltStore(path string, clock Clock, logger *slog.Logger) (*BoltStore, error) {
+	return storage_adapters.InitBoltAdapter(path, clock, logger)
+}
+
+// NewCircuitBreakerStore fails fast with ErrCircuitOpen after failureThreshold
+// consecutive errors of the store, probing it again after openDuration.
+func NewCircuitBreakerStore(store Store, failureThreshold int64, openDuration time.Duration, clock Clock, logger *slog.Logger) *CircuitBreakerStore {
+	return storage_adapters.NewCircuitBreakerAdapter(store, failureThreshold, openDuration, clock, logger)
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return storage_adapters.NewFakeClock(now)
+}
+
+func NewMetrics(registerer prometheus.Registerer, store Store) (*Metrics, error) {
+	return middlewares.NewMetrics(registerer, store)
+}
+
+// NewTokenRegistry resolves the custom tokens kept in the Store, see
+// WithTokenRegistry. logKeySecret hashes the logged tokens, as the one given to
+// WithLogKeySecret.
+func NewTokenRegistry(store Store, logger *slog.Logger, logKeySecret []byte) *TokenRegistry {
+	return middlewares.NewTokenRegistry(store, logger, logKeySecret)
+}